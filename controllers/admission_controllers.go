@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"waitlist/lib/tenant"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type admissionRequest struct {
+	Quota          int                    `json:"quota"`
+	Period         models.AdmissionPeriod `json:"period"`
+	ExpiresInHours int64                  `json:"expires_in_hours"`
+}
+
+func (w *Waitlist) GetAdmissionSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		settings, err := w.admissionSettings(context.Background(), w.scoped(c), list.Slug)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// Set the quota and cadence of the admission scheduler of a waitlist. A new cadence takes effect on the next run.
+func (w *Waitlist) UpdateAdmissionSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		collection := w.scoped(c).Collection("admission_settings")
+		ctx := context.Background()
+
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		request := admissionRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+		if request.Quota < 0 || request.Quota > maxWaveSize {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "quota must be between 0 and " + strconv.Itoa(maxWaveSize)})
+			return
+		}
+		if request.Period.Days() == 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "period must be day or week"})
+			return
+		}
+		if request.ExpiresInHours < 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "expires_in_hours can't be negative"})
+			return
+		}
+
+		now := time.Now().Unix()
+		update := bson.M{
+			"$set": bson.M{
+				"quota":            request.Quota,
+				"period":           request.Period,
+				"expires_in_hours": request.ExpiresInHours,
+				"updated_at":       now,
+			},
+			// a fresh scheduler runs straight away
+			"$setOnInsert": bson.M{"paused": false, "next_run_at": now},
+		}
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+		settings := models.AdmissionSettings{}
+		err := collection.FindOneAndUpdate(ctx, bson.M{"_id": list.Slug}, update, opts).Decode(&settings)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+func (w *Waitlist) PauseAdmission() gin.HandlerFunc {
+	return w.setAdmissionPaused(true)
+}
+
+func (w *Waitlist) ResumeAdmission() gin.HandlerFunc {
+	return w.setAdmissionPaused(false)
+}
+
+func (w *Waitlist) setAdmissionPaused(paused bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		collection := w.scoped(c).Collection("admission_settings")
+		ctx := context.Background()
+
+		update := bson.M{"$set": bson.M{"paused": paused, "updated_at": time.Now().Unix()}}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		settings := models.AdmissionSettings{}
+		err := collection.FindOneAndUpdate(ctx, bson.M{"_id": waitlistSlug(c)}, update, opts).Decode(&settings)
+		if err == mongo.ErrNoDocuments {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Admission scheduler is not configured"})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// Get the history of scheduler runs of a waitlist, newest first
+func (w *Waitlist) GetAdmissionRuns() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+
+		limit, skip, err := pagination(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "started_at", Value: -1}}).SetLimit(limit).SetSkip(skip)
+		cursor, err := w.scoped(c).Collection("admission_runs").Find(ctx, bson.M{"waitlist": waitlistSlug(c)}, opts)
+		if err != nil {
+			log.Println("MongoDb find error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching admission runs"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		runs := []models.AdmissionRun{}
+		if err := cursor.All(ctx, &runs); err != nil {
+			log.Println("MongoDb decode error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error decoding document"})
+			return
+		}
+
+		c.JSON(http.StatusOK, runs)
+	}
+}
+
+// admissionSettings returns the stored settings, or disabled defaults when none exist
+func (w *Waitlist) admissionSettings(ctx context.Context, db *tenant.Database, slug string) (*models.AdmissionSettings, error) {
+	settings := models.AdmissionSettings{Waitlist: slug, Period: models.DAILY_ADMISSION_PERIOD}
+	err := db.Collection("admission_settings").FindOne(ctx, bson.M{"_id": slug}).Decode(&settings)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return &settings, nil
+}
@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"time"
+	"waitlist/lib/mongolock"
+	"waitlist/lib/tenant"
+	"waitlist/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	admissionInterval = time.Minute
+	admissionLockTTL  = 5 * time.Minute
+)
+
+// RunAdmission admits the top of the queue on the configured cadence until ctx
+// is done. Only the replica holding the admission lock does any work.
+func (w *Waitlist) RunAdmission(ctx context.Context) {
+	lock := mongolock.New(w.db, "admission", admissionLockTTL)
+	defer lock.Release(context.Background())
+
+	ticker := time.NewTicker(admissionInterval)
+	defer ticker.Stop()
+
+	for {
+		leader, err := lock.Acquire(ctx)
+		if err != nil {
+			log.Println("unable to acquire admission lock:", err)
+		} else if leader {
+			w.admitDue(ctx, lock.Owner())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// admitDue runs the scheduler of every waitlist whose run is due, across organizations
+func (w *Waitlist) admitDue(ctx context.Context, owner string) {
+	filter := bson.M{
+		"paused":      false,
+		"quota":       bson.M{"$gt": 0},
+		"next_run_at": bson.M{"$lte": time.Now().Unix()},
+	}
+	cursor, err := w.db.Collection("admission_settings").Find(ctx, filter)
+	if err != nil {
+		log.Println("unable to read admission settings:", err)
+		return
+	}
+	due := []models.AdmissionSettings{}
+	if err := cursor.All(ctx, &due); err != nil {
+		log.Println("MongoDb decode error", err)
+		return
+	}
+
+	for i := range due {
+		w.admit(ctx, owner, &due[i])
+	}
+}
+
+// admit runs the scheduler of one waitlist
+func (w *Waitlist) admit(ctx context.Context, owner string, settings *models.AdmissionSettings) {
+	db := tenant.Scope(w.db, settings.TenantID)
+	now := time.Now()
+
+	// claim the run by moving next_run_at forward, so even a lost lock can't run it twice
+	next := now.AddDate(0, 0, settings.Period.Days()).Unix()
+	filter := bson.M{"_id": settings.Waitlist, "paused": false, "next_run_at": settings.NextRunAt}
+	update := bson.M{"$set": bson.M{"last_run_at": now.Unix(), "next_run_at": next}}
+	result, err := db.Collection("admission_settings").UpdateOne(ctx, filter, update)
+	if err != nil || result.ModifiedCount == 0 {
+		return
+	}
+
+	run := models.AdmissionRun{Waitlist: settings.Waitlist, Owner: owner, Quota: settings.Quota, StartedAt: now.Unix()}
+	wave := models.InviteWave{
+		Requested: settings.Quota,
+		Audience:  models.AudienceFilter{Waitlist: settings.Waitlist, ConfirmedOnly: true},
+		ExpiresIn: settings.ExpiresInHours,
+		Source:    "scheduler",
+	}
+
+	// bounced addresses are on the suppression list along with every other suppressed one
+	skip := func(entry *models.WaitlistEntry) bool {
+		suppressed, err := w.suppressions.IsSuppressed(ctx, settings.TenantID, entry.Email)
+		if err != nil {
+			log.Println("unable to check suppression for", entry.Email, err)
+		}
+		if suppressed || err != nil {
+			run.Skipped++
+			return true
+		}
+		return false
+	}
+
+	if err := w.releaseWave(ctx, db, &wave, skip); err != nil {
+		run.Error = err.Error()
+	}
+	run.WaveID = wave.ID
+	run.Admitted = wave.Released
+	run.CompletedAt = time.Now().Unix()
+
+	if _, err := db.Collection("admission_runs").InsertOne(ctx, run); err != nil {
+		log.Println("unable to record admission run:", err)
+	}
+}
@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// maxAcquisitionLength caps every captured acquisition value
+const maxAcquisitionLength = 500
+
+// acquisitionDimensions are the fields signups can be broken down by, keyed by their query name
+var acquisitionDimensions = map[string]string{
+	"source":   "$acquisition.source",
+	"medium":   "$acquisition.medium",
+	"campaign": "$acquisition.campaign",
+}
+
+type acquisitionRow struct {
+	Source           string  `json:"source,omitempty"`
+	Medium           string  `json:"medium,omitempty"`
+	Campaign         string  `json:"campaign,omitempty"`
+	Signups          int64   `json:"signups"`
+	Confirmed        int64   `json:"confirmed"`
+	ConfirmationRate float64 `json:"confirmation_rate"`
+}
+
+// Break signups and confirmation rates of a waitlist down by source, medium
+// and campaign. by picks the dimensions (default all three), from and to
+// bound the signup time as unix timestamps.
+func (w *Waitlist) GetAcquisitionAnalytics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		from, err := queryInt(c, "from", 0)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "from must be a unix timestamp"})
+			return
+		}
+		to, err := queryInt(c, "to", 0)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "to must be a unix timestamp"})
+			return
+		}
+
+		group := bson.M{}
+		for _, by := range strings.Split(c.DefaultQuery("by", "source,medium,campaign"), ",") {
+			field, ok := acquisitionDimensions[strings.TrimSpace(by)]
+			if !ok {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "by must be a list of source, medium and campaign"})
+				return
+			}
+			group[strings.TrimSpace(by)] = bson.M{"$ifNull": bson.A{field, ""}}
+		}
+
+		match := live(bson.M{"waitlist": list.Slug})
+		ts := bson.M{}
+		if from > 0 {
+			ts["$gte"] = from
+		}
+		if to > 0 {
+			ts["$lt"] = to
+		}
+		if len(ts) > 0 {
+			match["timestamp"] = ts
+		}
+
+		pipeline := bson.A{
+			bson.M{"$match": match},
+			bson.M{"$group": bson.M{
+				"_id":       group,
+				"signups":   bson.M{"$sum": 1},
+				"confirmed": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$confirmed_at", 0}}, 1, 0}}},
+			}},
+			bson.M{"$sort": bson.M{"signups": -1}},
+		}
+		cursor, err := w.scoped(c).Collection("waitlist").Aggregate(ctx, pipeline)
+		if err != nil {
+			log.Println("MongoDb aggregate error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while aggregating signups"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		results := []struct {
+			ID struct {
+				Source   string `bson:"source"`
+				Medium   string `bson:"medium"`
+				Campaign string `bson:"campaign"`
+			} `bson:"_id"`
+			Signups   int64 `bson:"signups"`
+			Confirmed int64 `bson:"confirmed"`
+		}{}
+		if err := cursor.All(ctx, &results); err != nil {
+			log.Println("MongoDb decode error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error decoding document"})
+			return
+		}
+
+		rows := make([]acquisitionRow, len(results))
+		total := acquisitionRow{}
+		for i, result := range results {
+			rows[i] = acquisitionRow{
+				Source:    result.ID.Source,
+				Medium:    result.ID.Medium,
+				Campaign:  result.ID.Campaign,
+				Signups:   result.Signups,
+				Confirmed: result.Confirmed,
+			}
+			rows[i].ConfirmationRate = rate(result.Confirmed, result.Signups)
+			total.Signups += result.Signups
+			total.Confirmed += result.Confirmed
+		}
+		total.ConfirmationRate = rate(total.Confirmed, total.Signups)
+
+		c.JSON(http.StatusOK, gin.H{"waitlist": list.Slug, "from": from, "to": to, "total": total, "breakdown": rows})
+	}
+}
+
+// acquisition completes what the signup form sent with the utm_* query
+// parameters of the request or of the landing page, and the request headers
+func acquisition(c *gin.Context, sent models.Acquisition) *models.Acquisition {
+	result := sent
+	if result.Referer == "" {
+		result.Referer = c.GetHeader("Referer")
+	}
+	result.UserAgent = c.GetHeader("User-Agent")
+
+	params := []url.Values{c.Request.URL.Query()}
+	if landing, err := url.Parse(result.LandingPage); err == nil {
+		params = append(params, landing.Query())
+	}
+	for _, values := range params {
+		fill(&result.Source, values.Get("utm_source"))
+		fill(&result.Medium, values.Get("utm_medium"))
+		fill(&result.Campaign, values.Get("utm_campaign"))
+		fill(&result.Term, values.Get("utm_term"))
+		fill(&result.Content, values.Get("utm_content"))
+	}
+
+	for _, value := range []*string{
+		&result.Source, &result.Medium, &result.Campaign, &result.Term,
+		&result.Content, &result.Referer, &result.LandingPage, &result.UserAgent,
+	} {
+		*value = truncate(strings.TrimSpace(*value), maxAcquisitionLength)
+	}
+	if result == (models.Acquisition{}) {
+		return nil
+	}
+	return &result
+}
+
+// fill sets value when it is still empty
+func fill(value *string, fallback string) {
+	if *value == "" {
+		*value = fallback
+	}
+}
+
+func truncate(value string, limit int) string {
+	runes := []rune(value)
+	if len(runes) <= limit {
+		return value
+	}
+	return string(runes[:limit])
+}
+
+// rate returns part/total, zero when total is zero
+func rate(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total)
+}
@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"waitlist/middleware"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// auditedEntry is the state of an entry kept in the audit log. The log is
+// hash chained and can't be erased, so entries are named by id and their
+// addresses, custom fields and note texts stay out of it.
+type auditedEntry struct {
+	ID          primitive.ObjectID   `json:"id"`
+	Waitlist    string               `json:"waitlist"`
+	Status      models.EntryStatus   `json:"status,omitempty"`
+	ConfirmedAt int64                `json:"confirmed_at,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Notes       []primitive.ObjectID `json:"notes,omitempty"`
+	Priority    bool                 `json:"priority,omitempty"`
+	Boost       float64              `json:"boost,omitempty"`
+	DeletedAt   int64                `json:"deleted_at,omitempty"`
+	PurgeAt     int64                `json:"purge_at,omitempty"`
+}
+
+// auditEntry records the state of an entry before and after the request,
+// naming the entry by id rather than by its address. Either can be nil.
+func auditEntry(c *gin.Context, before, after *models.WaitlistEntry) {
+	var id primitive.ObjectID
+	var states [2]interface{}
+	for i, entry := range []*models.WaitlistEntry{before, after} {
+		if entry == nil {
+			continue
+		}
+		id = entry.ID
+		state := auditedEntry{
+			ID:          entry.ID,
+			Waitlist:    entry.Waitlist,
+			Status:      entry.Status,
+			ConfirmedAt: entry.ConfirmedAt,
+			Tags:        entry.Tags,
+			Priority:    entry.Priority,
+			Boost:       entry.Boost,
+			DeletedAt:   entry.DeletedAt,
+			PurgeAt:     entry.PurgeAt,
+		}
+		for _, note := range entry.Notes {
+			state.Notes = append(state.Notes, note.ID)
+		}
+		states[i] = state
+	}
+
+	middleware.AuditTarget(c, "entry="+id.Hex())
+	middleware.AuditState(c, states[0], states[1])
+}
+
+// auditedSuppression is a suppression as kept in the audit log, without the
+// address or domain it suppresses
+type auditedSuppression struct {
+	ID        primitive.ObjectID       `json:"id"`
+	Kind      models.SuppressionKind   `json:"kind"`
+	Reason    models.SuppressionReason `json:"reason"`
+	Timestamp int64                    `json:"timestamp"`
+}
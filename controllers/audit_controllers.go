@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+	"waitlist/middleware"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Search the audit log of the organization, newest first, by actor, action,
+// target, request id and a unix time range (from, to). Targets match on any part.
+func (w *Waitlist) GetAuditLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+
+		filter := bson.M{}
+		for _, key := range []string{"actor", "action", "request_id"} {
+			if value := c.Query(key); value != "" {
+				filter[key] = value
+			}
+		}
+		if target := c.Query("target"); target != "" {
+			filter["target"] = bson.M{"$regex": regexp.QuoteMeta(target)}
+		}
+
+		from, err := queryInt(c, "from", 0)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "from must be a unix timestamp"})
+			return
+		}
+		to, err := queryInt(c, "to", 0)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "to must be a unix timestamp"})
+			return
+		}
+		ts := bson.M{}
+		if from > 0 {
+			ts["$gte"] = from
+		}
+		if to > 0 {
+			ts["$lt"] = to
+		}
+		if len(ts) > 0 {
+			filter["timestamp"] = ts
+		}
+
+		limit, skip, err := pagination(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "seq", Value: -1}}).SetLimit(limit).SetSkip(skip)
+		cursor, err := w.scoped(c).Collection("audit_log").Find(ctx, filter, opts)
+		if err != nil {
+			log.Println("MongoDb find error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the audit log"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		records := []models.AuditRecord{}
+		if err := cursor.All(ctx, &records); err != nil {
+			log.Println("MongoDb decode error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error decoding document"})
+			return
+		}
+
+		c.JSON(http.StatusOK, records)
+	}
+}
+
+// Check the hash chain of the organization's audit log. The returned head
+// hash can be kept elsewhere to also detect records removed from the end.
+func (w *Waitlist) VerifyAuditLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := w.trail.Verify(context.Background(), c.GetString(middleware.TenantKey))
+		if err != nil {
+			log.Println("unable to verify audit log:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
@@ -0,0 +1,451 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"waitlist/lib/tenant"
+	"waitlist/middleware"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// operations on more entries than this are queued as a job instead of answered inline
+	bulkSyncLimit = 500
+	// bulkBatchSize is how many entries go in one bulk write
+	bulkBatchSize = 500
+	maxBulkEmails = 50000
+)
+
+type bulkRequest struct {
+	Action models.BulkAction  `json:"action"`
+	Emails []string           `json:"emails"`
+	Filter *models.BulkFilter `json:"filter"`
+	Tags   []string           `json:"tags"`
+	Status models.EntryStatus `json:"status"`
+}
+
+func (r *bulkRequest) validate() string {
+	switch r.Action {
+	case models.DELETE_BULK_ACTION:
+	case models.TAG_BULK_ACTION, models.UNTAG_BULK_ACTION:
+		if len(models.NormalizeTags(r.Tags)) == 0 {
+			return "at least one tag is required"
+		}
+	case models.STATUS_BULK_ACTION:
+		// invites and expiries go through their own lifecycle, with a code per entry
+		if r.Status != models.WAITING_ENTRY_STATUS && r.Status != models.ACCEPTED_ENTRY_STATUS {
+			return "status must be waiting or accepted, use invite waves to invite entries"
+		}
+	default:
+		return "action must be delete, tag, untag or status"
+	}
+
+	if (len(r.Emails) > 0) == (r.Filter != nil) {
+		return "either emails or filter is required"
+	}
+	if len(r.Emails) > maxBulkEmails {
+		return "too many emails"
+	}
+	if r.Filter != nil && r.Filter.Status != "" && !r.Filter.Status.Valid() {
+		return "filter status must be waiting, invited, accepted or expired"
+	}
+	return ""
+}
+
+// Delete, tag, untag or change the status of many entries at once, picked by
+// a list of emails or a filter. Small operations answer with the result for
+// every email, larger ones are queued as a job to poll.
+func (w *Waitlist) BulkUpdateEntries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		db := w.scoped(c)
+
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		request := bulkRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+		if msg := request.validate(); msg != "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		now := time.Now().Unix()
+		job := models.BulkJob{
+			Waitlist:  list.Slug,
+			Action:    request.Action,
+			Emails:    uniqueEmails(request.Emails),
+			Filter:    request.Filter,
+			Tags:      models.NormalizeTags(request.Tags),
+			Status:    request.Status,
+			State:     models.QUEUED_BULK_JOB_STATE,
+			Results:   map[string]int64{},
+			CreatedBy: c.GetString(middleware.EmailKey),
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+
+		job.Total = int64(len(job.Emails))
+		if job.Filter != nil {
+			query, err := bulkQuery(list, job.Filter)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			job.Total, err = db.Collection("waitlist").CountDocuments(ctx, query)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+				return
+			}
+		}
+
+		if job.Total > bulkSyncLimit {
+			result, err := db.Collection("bulk_jobs").InsertOne(ctx, job)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to write to database", "message": err.Error()})
+				return
+			}
+			job.ID = result.InsertedID.(primitive.ObjectID)
+			middleware.AuditTarget(c, "waitlist="+list.Slug+" job="+job.ID.Hex())
+
+			c.JSON(http.StatusAccepted, job)
+			return
+		}
+
+		items := []models.BulkItem{}
+		err := w.applyBulk(ctx, db, list, &job, func(batch []models.BulkItem) error {
+			items = append(items, batch...)
+			return nil
+		})
+		if err != nil {
+			log.Println("unable to apply bulk operation:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+		for _, item := range items {
+			job.Results[string(item.Result)]++
+		}
+		middleware.AuditTarget(c, "waitlist="+list.Slug)
+
+		c.JSON(http.StatusOK, gin.H{"action": job.Action, "total": len(items), "results": job.Results, "items": items})
+	}
+}
+
+// Get a queued bulk job and its progress
+func (w *Waitlist) GetBulkJob() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid job id"})
+			return
+		}
+
+		job := models.BulkJob{}
+		err = w.scoped(c).Collection("bulk_jobs").FindOne(context.Background(), bson.M{"_id": id}).Decode(&job)
+		if err == mongo.ErrNoDocuments {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Job not found"})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, job)
+	}
+}
+
+// Get the per email results of a bulk job, optionally only one kind of result
+func (w *Waitlist) GetBulkJobItems() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid job id"})
+			return
+		}
+
+		filter := bson.M{"job_id": id}
+		if result := c.Query("result"); result != "" {
+			filter["result"] = result
+		}
+		limit, skip, err := pagination(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(limit).SetSkip(skip)
+		cursor, err := w.scoped(c).Collection("bulk_items").Find(ctx, filter, opts)
+		if err != nil {
+			log.Println("MongoDb find error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching results"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		items := []models.BulkItem{}
+		if err := cursor.All(ctx, &items); err != nil {
+			log.Println("MongoDb decode error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error decoding document"})
+			return
+		}
+
+		c.JSON(http.StatusOK, items)
+	}
+}
+
+// applyBulk runs job over its entries in batches, handing the results of every
+// batch to record. Emails without a live entry are reported as not found.
+func (w *Waitlist) applyBulk(ctx context.Context, db *tenant.Database, list *models.Waitlist, job *models.BulkJob, record func([]models.BulkItem) error) error {
+	collection := db.Collection("waitlist")
+
+	if job.Filter != nil {
+		query, err := bulkQuery(list, job.Filter)
+		if err != nil {
+			return err
+		}
+		opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetBatchSize(bulkBatchSize)
+		cursor, err := collection.Find(ctx, query, opts)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+
+		batch := make([]models.WaitlistEntry, 0, bulkBatchSize)
+		for cursor.Next(ctx) {
+			entry := models.WaitlistEntry{}
+			if err := cursor.Decode(&entry); err != nil {
+				return err
+			}
+			batch = append(batch, entry)
+			if len(batch) == bulkBatchSize {
+				if err := record(w.applyBulkBatch(ctx, db, list, job, batch)); err != nil {
+					return err
+				}
+				batch = batch[:0]
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			return err
+		}
+		if len(batch) > 0 {
+			return record(w.applyBulkBatch(ctx, db, list, job, batch))
+		}
+		return nil
+	}
+
+	for start := 0; start < len(job.Emails); start += bulkBatchSize {
+		emails := job.Emails[start:min(start+bulkBatchSize, len(job.Emails))]
+
+		entries := []models.WaitlistEntry{}
+		filter := live(bson.M{"waitlist": list.Slug, "email": bson.M{"$in": emails}})
+		if err := findAll(ctx, collection, filter, &entries); err != nil {
+			return err
+		}
+		applied := map[string]models.BulkItem{}
+		for _, item := range w.applyBulkBatch(ctx, db, list, job, entries) {
+			applied[item.Email] = item
+		}
+
+		items := make([]models.BulkItem, len(emails))
+		for i, email := range emails {
+			item, ok := applied[email]
+			if !ok {
+				item = models.BulkItem{Email: email, Result: models.NOT_FOUND_BULK_RESULT}
+			}
+			items[i] = item
+		}
+		if err := record(items); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyBulkBatch applies the action of job to entries in a single unordered
+// bulk write, so one failing entry doesn't hold back the others. Entries the
+// action wouldn't change are left alone.
+func (w *Waitlist) applyBulkBatch(ctx context.Context, db *tenant.Database, list *models.Waitlist, job *models.BulkJob, entries []models.WaitlistEntry) []models.BulkItem {
+	collection := db.Collection("waitlist")
+	items := make([]models.BulkItem, len(entries))
+	writes := []mongo.WriteModel{}
+	// written holds the index in items of every write
+	written := []int{}
+	now := time.Now()
+
+	for i := range entries {
+		entry := &entries[i]
+		items[i] = models.BulkItem{Email: entry.Email, Result: models.UPDATED_BULK_RESULT}
+
+		var update bson.M
+		switch job.Action {
+		case models.DELETE_BULK_ACTION:
+			entry.DeletedAt, entry.PurgeAt = now.Unix(), now.AddDate(0, 0, retentionDays(list)).Unix()
+			update = bson.M{"$set": bson.M{"deleted_at": entry.DeletedAt, "purge_at": entry.PurgeAt}}
+			items[i].Result = models.DELETED_BULK_RESULT
+		case models.TAG_BULK_ACTION:
+			if !containsAll(entry.Tags, job.Tags) {
+				update = bson.M{"$addToSet": bson.M{"tags": bson.M{"$each": job.Tags}}}
+			}
+		case models.UNTAG_BULK_ACTION:
+			if containsAny(entry.Tags, job.Tags) {
+				update = bson.M{"$pull": bson.M{"tags": bson.M{"$in": job.Tags}}}
+			}
+		case models.STATUS_BULK_ACTION:
+			if entry.Status == job.Status || (entry.Status == "" && job.Status == models.WAITING_ENTRY_STATUS) {
+				break
+			}
+			if job.Status == models.WAITING_ENTRY_STATUS {
+				// back to the pool, keeping the original signup time and so the original position
+				update = bson.M{"$set": bson.M{"status": job.Status}, "$unset": bson.M{"invited_at": "", "accepted_at": ""}}
+			} else {
+				update = bson.M{"$set": bson.M{"status": job.Status, "accepted_at": now.Unix()}}
+			}
+		}
+		if update == nil {
+			items[i].Result = models.UNCHANGED_BULK_RESULT
+			continue
+		}
+
+		writes = append(writes, mongo.NewUpdateOneModel().SetFilter(live(bson.M{"_id": entry.ID})).SetUpdate(update))
+		written = append(written, i)
+	}
+	if len(writes) == 0 {
+		return items
+	}
+
+	failed := map[int]string{}
+	_, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) {
+		for _, writeErr := range bulkErr.WriteErrors {
+			failed[writeErr.Index] = writeErr.Message
+		}
+	} else if err != nil {
+		log.Println("MongoDb bulk write error:", err)
+		for n := range written {
+			failed[n] = "Database error"
+		}
+	}
+
+	ids := bson.A{}
+	for n, i := range written {
+		if msg, ok := failed[n]; ok {
+			items[i].Result = models.FAILED_BULK_RESULT
+			items[i].Error = msg
+			continue
+		}
+		ids = append(ids, entries[i].ID)
+		if job.Action == models.DELETE_BULK_ACTION {
+			w.emit(models.ENTRY_DELETED_EVENT_TYPE, &entries[i])
+		}
+	}
+
+	if job.Action == models.STATUS_BULK_ACTION && len(ids) > 0 {
+		// codes sent to these entries no longer lead anywhere
+		filter := bson.M{"entry_id": bson.M{"$in": ids}, "status": models.SENT_INVITE_STATUS}
+		update := bson.M{"$set": bson.M{"status": models.REVOKED_INVITE_STATUS}}
+		if _, err := db.Collection("invites").UpdateMany(ctx, filter, update); err != nil {
+			log.Println("unable to revoke invites:", err)
+		}
+	}
+
+	if job.Action == models.TAG_BULK_ACTION || job.Action == models.UNTAG_BULK_ACTION {
+		if err := syncPriority(ctx, collection, list, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+			log.Println("unable to reorder waitlist:", err)
+		}
+		if err := w.rescore(ctx, db, list, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+			log.Println("unable to rescore entries:", err)
+		}
+	}
+	return items
+}
+
+// bulkQuery returns the waitlist filter selecting the live entries of list matched by filter
+func bulkQuery(list *models.Waitlist, filter *models.BulkFilter) (bson.M, error) {
+	query, err := fieldFilter(list.Fields, filter.Fields)
+	if err != nil {
+		return nil, err
+	}
+	query["waitlist"] = list.Slug
+	live(query)
+
+	switch filter.Status {
+	case "":
+	case models.WAITING_ENTRY_STATUS:
+		// entries created before statuses existed count as waiting
+		query["status"] = bson.M{"$in": bson.A{nil, models.WAITING_ENTRY_STATUS}}
+	default:
+		query["status"] = filter.Status
+	}
+	if filter.Confirmed != nil {
+		if *filter.Confirmed {
+			query["confirmed_at"] = bson.M{"$gt": 0}
+		} else {
+			query["confirmed_at"] = bson.M{"$in": bson.A{nil, 0}}
+		}
+	}
+
+	timestamp := bson.M{}
+	if filter.SignedUpAfter > 0 {
+		timestamp["$gte"] = filter.SignedUpAfter
+	}
+	if filter.SignedUpBefore > 0 {
+		timestamp["$lt"] = filter.SignedUpBefore
+	}
+	if len(timestamp) > 0 {
+		query["timestamp"] = timestamp
+	}
+
+	return tagQuery(query, filter.Tags), nil
+}
+
+// uniqueEmails returns emails trimmed, without blanks and duplicates, in their original order
+func uniqueEmails(emails []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, email := range emails {
+		email = strings.TrimSpace(email)
+		if email == "" || seen[email] {
+			continue
+		}
+		seen[email] = true
+		unique = append(unique, email)
+	}
+	return unique
+}
+
+// containsAll reports whether values holds every one of wanted
+func containsAll(values []string, wanted []string) bool {
+	for _, value := range wanted {
+		if !contains(values, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsAny reports whether values holds at least one of wanted
+func containsAny(values []string, wanted []string) bool {
+	for _, value := range wanted {
+		if contains(values, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"time"
+	"waitlist/lib/tenant"
+	"waitlist/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const bulkInterval = 5 * time.Second
+
+// RunBulkJobs processes queued bulk jobs until ctx is done
+func (w *Waitlist) RunBulkJobs(ctx context.Context) {
+	ticker := time.NewTicker(bulkInterval)
+	defer ticker.Stop()
+
+	for {
+		w.processBulkJobs(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processBulkJobs claims queued jobs of every organization one at a time, so
+// replicas never run the same job twice. Jobs left running by a crashed
+// replica are queued again and start over.
+func (w *Waitlist) processBulkJobs(ctx context.Context) {
+	jobs := w.db.Collection("bulk_jobs")
+
+	stale := bson.M{
+		"state":      models.RUNNING_BULK_JOB_STATE,
+		"updated_at": bson.M{"$lt": time.Now().Add(-sendClaimTimeout).Unix()},
+	}
+	requeue := bson.M{"$set": bson.M{"state": models.QUEUED_BULK_JOB_STATE}}
+	if _, err := jobs.UpdateMany(ctx, stale, requeue); err != nil {
+		log.Println("unable to requeue bulk jobs:", err)
+	}
+
+	for {
+		job := models.BulkJob{}
+		claim := bson.M{"$set": bson.M{
+			"state":      models.RUNNING_BULK_JOB_STATE,
+			"processed":  0,
+			"results":    bson.M{},
+			"updated_at": time.Now().Unix(),
+		}}
+		opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetReturnDocument(options.After)
+		err := jobs.FindOneAndUpdate(ctx, bson.M{"state": models.QUEUED_BULK_JOB_STATE}, claim, opts).Decode(&job)
+		if err == mongo.ErrNoDocuments {
+			return
+		} else if err != nil {
+			log.Println("unable to claim bulk job:", err)
+			return
+		}
+
+		w.processBulkJob(ctx, &job)
+	}
+}
+
+// processBulkJob applies job, storing the results of every batch as it goes
+func (w *Waitlist) processBulkJob(ctx context.Context, job *models.BulkJob) {
+	db := tenant.Scope(w.db, job.TenantID)
+	jobs := db.Collection("bulk_jobs")
+	items := db.Collection("bulk_items")
+
+	// results of an earlier, interrupted run are replaced
+	if _, err := items.DeleteMany(ctx, bson.M{"job_id": job.ID}); err != nil {
+		log.Println("unable to reset bulk job results:", err)
+		return
+	}
+
+	list, err := w.findWaitlist(ctx, db, job.Waitlist)
+	if err == nil {
+		err = w.applyBulk(ctx, db, list, job, func(batch []models.BulkItem) error {
+			docs := make([]interface{}, len(batch))
+			results := map[models.BulkResult]int64{}
+			for i := range batch {
+				batch[i].JobID = job.ID
+				docs[i] = batch[i]
+				results[batch[i].Result]++
+			}
+			inc := bson.M{"processed": int64(len(batch))}
+			for result, count := range results {
+				inc["results."+string(result)] = count
+			}
+			if _, err := items.InsertMany(ctx, docs); err != nil {
+				return err
+			}
+			update := bson.M{"$inc": inc, "$set": bson.M{"updated_at": time.Now().Unix()}}
+			_, err := jobs.UpdateOne(ctx, bson.M{"_id": job.ID}, update)
+			return err
+		})
+	}
+
+	now := time.Now().Unix()
+	set := bson.M{"state": models.COMPLETED_BULK_JOB_STATE, "updated_at": now, "completed_at": now}
+	if err != nil {
+		log.Println("unable to process bulk job:", err)
+		set["state"] = models.FAILED_BULK_JOB_STATE
+		set["error"] = err.Error()
+	}
+	if _, err := jobs.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": set}); err != nil {
+		log.Println("unable to update bulk job:", err)
+	}
+}
@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type campaignRequest struct {
+	Name          string                `json:"name"`
+	TemplateAlias string                `json:"template_alias"`
+	Audience      models.AudienceFilter `json:"audience"`
+	ScheduledAt   int64                 `json:"scheduled_at"`
+}
+
+func (w *Waitlist) CreateCampaign() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		collection := w.scoped(c).Collection("campaigns")
+		ctx := context.Background()
+
+		request := campaignRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+
+		if request.Name == "" || request.TemplateAlias == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "name and template_alias are required"})
+			return
+		}
+		if request.Audience.Waitlist == "" {
+			request.Audience.Waitlist = waitlistSlug(c)
+		}
+		if _, err := w.findWaitlist(ctx, w.scoped(c), request.Audience.Waitlist); err == errWaitlistNotFound {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Waitlist not found"})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		now := time.Now().Unix()
+		campaign := models.Campaign{
+			Name:          request.Name,
+			TemplateAlias: request.TemplateAlias,
+			Audience:      request.Audience,
+			ScheduledAt:   request.ScheduledAt,
+			Status:        models.SCHEDULED_CAMPAIGN_STATUS,
+			CreatedAt:     now,
+		}
+		if campaign.ScheduledAt == 0 {
+			campaign.ScheduledAt = now
+		}
+
+		result, err := collection.InsertOne(ctx, campaign)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to write to database", "message": err.Error()})
+			return
+		}
+		campaign.ID = result.InsertedID.(primitive.ObjectID)
+
+		c.JSON(http.StatusCreated, campaign)
+	}
+}
+
+func (w *Waitlist) GetCampaigns() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		collection := w.scoped(c).Collection("campaigns")
+		ctx := context.Background()
+
+		filter := bson.M{}
+		if slug := c.Query("waitlist"); slug != "" {
+			filter["audience.waitlist"] = slug
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+		cursor, err := collection.Find(ctx, filter, opts)
+		if err != nil {
+			log.Println("MongoDb find error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching campaigns"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		campaigns := []models.Campaign{}
+		if err := cursor.All(ctx, &campaigns); err != nil {
+			log.Println("MongoDb decode error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error decoding document"})
+			return
+		}
+
+		c.JSON(http.StatusOK, campaigns)
+	}
+}
+
+// Get a single campaign, including its aggregate progress
+func (w *Waitlist) GetCampaign() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid campaign id"})
+			return
+		}
+
+		campaign := models.Campaign{}
+		err = w.scoped(c).Collection("campaigns").FindOne(context.Background(), bson.M{"_id": id}).Decode(&campaign)
+		if err == mongo.ErrNoDocuments {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Campaign not found"})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, campaign)
+	}
+}
+
+// Get the per-recipient status of a campaign, optionally filtered by status
+func (w *Waitlist) GetCampaignRecipients() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid campaign id"})
+			return
+		}
+
+		filter := bson.M{"campaign_id": id}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+
+		cursor, err := w.scoped(c).Collection("campaign_recipients").Find(ctx, filter)
+		if err != nil {
+			log.Println("MongoDb find error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching recipients"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		recipients := []models.CampaignRecipient{}
+		if err := cursor.All(ctx, &recipients); err != nil {
+			log.Println("MongoDb decode error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error decoding document"})
+			return
+		}
+
+		c.JSON(http.StatusOK, recipients)
+	}
+}
+
+func (w *Waitlist) PauseCampaign() gin.HandlerFunc {
+	return w.transitionCampaign(
+		[]models.CampaignStatus{models.SCHEDULED_CAMPAIGN_STATUS, models.RUNNING_CAMPAIGN_STATUS},
+		models.PAUSED_CAMPAIGN_STATUS,
+	)
+}
+
+func (w *Waitlist) ResumeCampaign() gin.HandlerFunc {
+	return w.transitionCampaign(
+		[]models.CampaignStatus{models.PAUSED_CAMPAIGN_STATUS},
+		models.RUNNING_CAMPAIGN_STATUS,
+	)
+}
+
+func (w *Waitlist) CancelCampaign() gin.HandlerFunc {
+	return w.transitionCampaign(
+		[]models.CampaignStatus{models.SCHEDULED_CAMPAIGN_STATUS, models.RUNNING_CAMPAIGN_STATUS, models.PAUSED_CAMPAIGN_STATUS},
+		models.CANCELLED_CAMPAIGN_STATUS,
+	)
+}
+
+// transitionCampaign moves a campaign to status when it is currently in one of from
+func (w *Waitlist) transitionCampaign(from []models.CampaignStatus, status models.CampaignStatus) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		collection := w.scoped(c).Collection("campaigns")
+		ctx := context.Background()
+
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid campaign id"})
+			return
+		}
+
+		var update interface{}
+		switch status {
+		case models.RUNNING_CAMPAIGN_STATUS:
+			// a campaign paused before it started goes back to waiting for its schedule
+			update = bson.A{bson.M{"$set": bson.M{"status": bson.M{
+				"$cond": bson.A{"$materialized", models.RUNNING_CAMPAIGN_STATUS, models.SCHEDULED_CAMPAIGN_STATUS},
+			}}}}
+		case models.CANCELLED_CAMPAIGN_STATUS:
+			update = bson.M{"$set": bson.M{"status": status, "completed_at": time.Now().Unix()}}
+		default:
+			update = bson.M{"$set": bson.M{"status": status}}
+		}
+
+		filter := bson.M{"_id": id, "status": bson.M{"$in": from}}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		campaign := models.Campaign{}
+		err = collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&campaign)
+		if err == mongo.ErrNoDocuments {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "campaign not found or cannot move to " + string(status)})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		if status == models.CANCELLED_CAMPAIGN_STATUS {
+			if err := w.cancelQueuedRecipients(ctx, &campaign); err != nil {
+				log.Println("unable to cancel campaign recipients:", err)
+			}
+		}
+
+		c.JSON(http.StatusOK, campaign)
+	}
+}
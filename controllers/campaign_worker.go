@@ -0,0 +1,278 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"time"
+	"waitlist/lib/emailclient"
+	"waitlist/lib/tenant"
+	"waitlist/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	campaignInterval  = 10 * time.Second
+	campaignBatchSize = 100
+	// recipients stuck in sending this long are assumed lost to a crash
+	sendClaimTimeout = 5 * time.Minute
+)
+
+// RunCampaigns starts due campaigns and sends running ones in batches until ctx is done
+func (w *Waitlist) RunCampaigns(ctx context.Context) {
+	ticker := time.NewTicker(campaignInterval)
+	defer ticker.Stop()
+
+	for {
+		w.startDueCampaigns(ctx)
+		w.sendCampaignBatches(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// startDueCampaigns claims scheduled campaigns whose time has come. Campaigns
+// of every organization are handled, each one in the tenant that created it.
+func (w *Waitlist) startDueCampaigns(ctx context.Context) {
+	collection := w.db.Collection("campaigns")
+	now := time.Now().Unix()
+
+	for {
+		filter := bson.M{"status": models.SCHEDULED_CAMPAIGN_STATUS, "scheduled_at": bson.M{"$lte": now}}
+		update := bson.M{"$set": bson.M{"status": models.RUNNING_CAMPAIGN_STATUS, "started_at": now}}
+		err := collection.FindOneAndUpdate(ctx, filter, update).Err()
+		if err == mongo.ErrNoDocuments {
+			return
+		} else if err != nil {
+			log.Println("unable to start campaign:", err)
+			return
+		}
+	}
+}
+
+func (w *Waitlist) sendCampaignBatches(ctx context.Context) {
+	cursor, err := w.db.Collection("campaigns").Find(ctx, bson.M{"status": models.RUNNING_CAMPAIGN_STATUS})
+	if err != nil {
+		log.Println("MongoDb find error:", err)
+		return
+	}
+	campaigns := []models.Campaign{}
+	if err := cursor.All(ctx, &campaigns); err != nil {
+		log.Println("MongoDb decode error", err)
+		return
+	}
+
+	for i := range campaigns {
+		campaign := &campaigns[i]
+		if !campaign.Materialized {
+			if err := w.materializeCampaign(ctx, campaign); err != nil {
+				log.Println("unable to queue campaign recipients:", err)
+				continue
+			}
+		}
+		if err := w.requeueStaleRecipients(ctx, campaign); err != nil {
+			log.Println("unable to requeue campaign recipients:", err)
+		}
+		if err := w.sendCampaignBatch(ctx, campaign); err != nil {
+			log.Println("unable to send campaign batch:", err)
+		}
+	}
+}
+
+// materializeCampaign queues one recipient per audience entry. It is safe to
+// repeat after a crash because recipients are unique per campaign.
+func (w *Waitlist) materializeCampaign(ctx context.Context, campaign *models.Campaign) error {
+	db := tenant.Scope(w.db, campaign.TenantID)
+	recipients := db.Collection("campaign_recipients")
+	opts := options.Find().SetProjection(bson.M{"email": 1})
+	cursor, err := db.Collection("waitlist").Find(ctx, campaign.Audience.Query(), opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	now := time.Now().Unix()
+	batch := []interface{}{}
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		_, err := recipients.InsertMany(ctx, batch, options.InsertMany().SetOrdered(false))
+		batch = batch[:0]
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		var entry models.WaitlistEntry
+		if err := cursor.Decode(&entry); err != nil {
+			return err
+		}
+		batch = append(batch, models.CampaignRecipient{
+			CampaignID: campaign.ID,
+			EntryID:    entry.ID,
+			Email:      entry.Email,
+			Status:     models.QUEUED_RECIPIENT_STATUS,
+			UpdatedAt:  now,
+		})
+		if len(batch) == campaignBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	total, err := recipients.CountDocuments(ctx, bson.M{"campaign_id": campaign.ID})
+	if err != nil {
+		return err
+	}
+	campaign.Materialized = true
+	campaign.Progress.Total = total
+	campaign.Progress.Queued = total
+	update := bson.M{"$set": bson.M{"materialized": true, "progress.total": total, "progress.queued": total}}
+	_, err = db.Collection("campaigns").UpdateOne(ctx, bson.M{"_id": campaign.ID}, update)
+	return err
+}
+
+// sendCampaignBatch sends up to campaignBatchSize queued recipients in one batch.
+// Each recipient is claimed individually so several replicas never double send.
+func (w *Waitlist) sendCampaignBatch(ctx context.Context, campaign *models.Campaign) error {
+	db := tenant.Scope(w.db, campaign.TenantID)
+
+	// stop as soon as the campaign is paused or cancelled
+	if !w.campaignRunning(ctx, campaign) {
+		return nil
+	}
+
+	recipients := db.Collection("campaign_recipients")
+	claimed := []models.CampaignRecipient{}
+	for len(claimed) < campaignBatchSize {
+		recipient := models.CampaignRecipient{}
+		filter := bson.M{"campaign_id": campaign.ID, "status": models.QUEUED_RECIPIENT_STATUS}
+		claim := bson.M{"$set": bson.M{"status": models.SENDING_RECIPIENT_STATUS, "updated_at": time.Now().Unix()}}
+		err := recipients.FindOneAndUpdate(ctx, filter, claim).Decode(&recipient)
+		if err == mongo.ErrNoDocuments {
+			break
+		} else if err != nil {
+			return err
+		}
+		claimed = append(claimed, recipient)
+	}
+	if len(claimed) == 0 {
+		return w.completeCampaign(ctx, campaign)
+	}
+
+	list, err := w.findWaitlist(ctx, db, campaign.Audience.Waitlist)
+	if err != nil {
+		return err
+	}
+
+	messages := make([]*models.Message, len(claimed))
+	for i, recipient := range claimed {
+		messages[i] = &models.Message{
+			CustomerID: recipient.EntryID.Hex(),
+			AccountID:  list.TenantID,
+			Waitlist:   list.Slug,
+			Sender:     list.SenderEmail,
+			Target:     recipient.Email,
+			Type:       models.EMAIL_MESSAGE_TYPE,
+			Title:      campaign.Name,
+			TemplateID: campaign.TemplateAlias,
+			DataMap:    map[string]string{"Email": recipient.Email},
+		}
+	}
+
+	for i, result := range w.notifier.SendBatch(messages) {
+		status := models.SENT_RECIPIENT_STATUS
+		if result.Err == emailclient.ErrSuppressed {
+			status = models.SKIPPED_RECIPIENT_STATUS
+		} else if result.Err != nil {
+			status = models.FAILED_RECIPIENT_STATUS
+		}
+		if err := w.finishRecipient(ctx, campaign, &claimed[i], status, result.Err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Waitlist) finishRecipient(ctx context.Context, campaign *models.Campaign, recipient *models.CampaignRecipient, status models.RecipientStatus, sendErr error) error {
+	db := tenant.Scope(w.db, campaign.TenantID)
+	set := bson.M{"status": status, "updated_at": time.Now().Unix()}
+	if sendErr != nil {
+		set["error"] = sendErr.Error()
+	}
+	if _, err := db.Collection("campaign_recipients").UpdateOne(ctx, bson.M{"_id": recipient.ID}, bson.M{"$set": set}); err != nil {
+		return err
+	}
+
+	progress := bson.M{"$inc": bson.M{"progress.queued": -1, "progress." + string(status): 1}}
+	_, err := db.Collection("campaigns").UpdateOne(ctx, bson.M{"_id": campaign.ID}, progress)
+	return err
+}
+
+func (w *Waitlist) campaignRunning(ctx context.Context, campaign *models.Campaign) bool {
+	db := tenant.Scope(w.db, campaign.TenantID)
+	current := models.Campaign{}
+	opts := options.FindOne().SetProjection(bson.M{"status": 1})
+	if err := db.Collection("campaigns").FindOne(ctx, bson.M{"_id": campaign.ID}, opts).Decode(&current); err != nil {
+		log.Println("MongoDb find error:", err)
+		return false
+	}
+	return current.Status == models.RUNNING_CAMPAIGN_STATUS
+}
+
+// completeCampaign marks the campaign done once no recipient is queued or in flight
+func (w *Waitlist) completeCampaign(ctx context.Context, campaign *models.Campaign) error {
+	db := tenant.Scope(w.db, campaign.TenantID)
+	inFlight, err := db.Collection("campaign_recipients").CountDocuments(ctx, bson.M{
+		"campaign_id": campaign.ID,
+		"status":      bson.M{"$in": []models.RecipientStatus{models.QUEUED_RECIPIENT_STATUS, models.SENDING_RECIPIENT_STATUS}},
+	})
+	if err != nil || inFlight > 0 {
+		return err
+	}
+
+	filter := bson.M{"_id": campaign.ID, "status": models.RUNNING_CAMPAIGN_STATUS}
+	update := bson.M{"$set": bson.M{"status": models.COMPLETED_CAMPAIGN_STATUS, "completed_at": time.Now().Unix()}}
+	_, err = db.Collection("campaigns").UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (w *Waitlist) requeueStaleRecipients(ctx context.Context, campaign *models.Campaign) error {
+	db := tenant.Scope(w.db, campaign.TenantID)
+	filter := bson.M{
+		"campaign_id": campaign.ID,
+		"status":      models.SENDING_RECIPIENT_STATUS,
+		"updated_at":  bson.M{"$lt": time.Now().Add(-sendClaimTimeout).Unix()},
+	}
+	update := bson.M{"$set": bson.M{"status": models.QUEUED_RECIPIENT_STATUS}}
+	_, err := db.Collection("campaign_recipients").UpdateMany(ctx, filter, update)
+	return err
+}
+
+// cancelQueuedRecipients drops every recipient that has not been sent yet
+func (w *Waitlist) cancelQueuedRecipients(ctx context.Context, campaign *models.Campaign) error {
+	db := tenant.Scope(w.db, campaign.TenantID)
+	filter := bson.M{"campaign_id": campaign.ID, "status": models.QUEUED_RECIPIENT_STATUS}
+	update := bson.M{"$set": bson.M{"status": models.CANCELLED_RECIPIENT_STATUS, "updated_at": time.Now().Unix()}}
+	result, err := db.Collection("campaign_recipients").UpdateMany(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	progress := bson.M{"$inc": bson.M{"progress.queued": -result.ModifiedCount}}
+	_, err = db.Collection("campaigns").UpdateOne(ctx, bson.M{"_id": campaign.ID}, progress)
+	return err
+}
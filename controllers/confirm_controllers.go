@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+	"waitlist/lib/linksigner"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Confirm an email address from the signed link sent at signup
+func (w *Waitlist) ConfirmEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+
+		email := c.Query("email")
+		if !w.signer.Verify(linksigner.ConfirmPurpose, email, c.Query("token")) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "invalid confirmation link"})
+			return
+		}
+		list, ok := w.loadPublicWaitlist(c)
+		if !ok {
+			return
+		}
+		collection := w.scoped(c).Collection("waitlist")
+
+		entry := models.WaitlistEntry{}
+		err := collection.FindOne(ctx, live(bson.M{"waitlist": list.Slug, "email": email})).Decode(&entry)
+		if err == mongo.ErrNoDocuments {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Email not found"})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+		if entry.ConfirmedAt > 0 {
+			c.JSON(http.StatusOK, gin.H{"message": "Email already confirmed"})
+			return
+		}
+
+		// only the request that confirms the entry credits its referrer
+		entry.ConfirmedAt = time.Now().Unix()
+		filter := bson.M{"_id": entry.ID, "confirmed_at": bson.M{"$not": bson.M{"$gt": 0}}}
+		update := bson.M{"$set": bson.M{"confirmed_at": entry.ConfirmedAt}}
+		result, err := collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+		if result.ModifiedCount == 0 {
+			c.JSON(http.StatusOK, gin.H{"message": "Email already confirmed"})
+			return
+		}
+		w.emit(models.ENTRY_CONFIRMED_EVENT_TYPE, &entry)
+		if err := w.rescore(ctx, w.scoped(c), list, bson.M{"_id": entry.ID}); err != nil {
+			log.Println("unable to rescore entry:", err)
+		}
+		// referrals count once the referred address is confirmed to be real
+		if !entry.ReferredBy.IsZero() {
+			if err := w.creditReferral(ctx, w.scoped(c), list, entry.ReferredBy); err != nil {
+				log.Println("unable to credit referral:", err)
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Email confirmed"})
+	}
+}
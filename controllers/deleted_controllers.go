@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// defaultRetentionDays is how long deleted entries are kept when a waitlist doesn't say
+const defaultRetentionDays = 30
+
+// Get the deleted entries of a waitlist that can still be restored, most recently deleted first
+func (w *Waitlist) GetDeletedEntries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		limit, skip, err := pagination(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		filter := bson.M{"waitlist": list.Slug, "deleted_at": bson.M{"$exists": true}, "purge_at": bson.M{"$gt": time.Now().Unix()}}
+		opts := options.Find().SetSort(bson.D{{Key: "deleted_at", Value: -1}}).SetLimit(limit).SetSkip(skip)
+		cursor, err := w.scoped(c).Collection("waitlist").Find(ctx, filter, opts)
+		if err != nil {
+			log.Println("MongoDb find error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching records"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		entries := []models.WaitlistEntry{}
+		if err := cursor.All(ctx, &entries); err != nil {
+			log.Println("MongoDb decode error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error decoding document"})
+			return
+		}
+
+		c.JSON(http.StatusOK, entries)
+	}
+}
+
+// Restore a deleted entry with its original signup time, and so its original
+// position. An address that signed up again since can't be restored over.
+func (w *Waitlist) RestoreEntry() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		collection := w.scoped(c).Collection("waitlist")
+		ctx := context.Background()
+		email := c.Param("email")
+
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		existing, err := collection.CountDocuments(ctx, live(bson.M{"waitlist": list.Slug, "email": email}))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+		if existing > 0 {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "Email is already on the waitlist"})
+			return
+		}
+
+		filter := bson.M{"waitlist": list.Slug, "email": email, "deleted_at": bson.M{"$exists": true}, "purge_at": bson.M{"$gt": time.Now().Unix()}}
+		update := bson.M{"$unset": bson.M{"deleted_at": "", "purge_at": ""}}
+		opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "deleted_at", Value: -1}})
+
+		before := models.WaitlistEntry{}
+		err = collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&before)
+		if err == mongo.ErrNoDocuments {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "No restorable entry for this email"})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		entry := before
+		entry.DeletedAt, entry.PurgeAt = 0, 0
+		if err := w.rescore(ctx, w.scoped(c), list, bson.M{"_id": entry.ID}); err != nil {
+			log.Println("unable to rescore entry:", err)
+		}
+		auditEntry(c, &before, &entry)
+
+		c.JSON(http.StatusOK, entry)
+	}
+}
+
+// retentionDays returns how many days deleted entries of list can be restored
+func retentionDays(list *models.Waitlist) int {
+	if list.Settings.RetentionDays > 0 {
+		return list.Settings.RetentionDays
+	}
+	return defaultRetentionDays
+}
@@ -0,0 +1,230 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type dripRequest struct {
+	Name     string            `json:"name"`
+	Active   bool              `json:"active"`
+	Steps    []models.DripStep `json:"steps"`
+	StartsAt int64             `json:"starts_at"`
+}
+
+// validate checks the steps can be scheduled and returns a message for the caller
+func (r *dripRequest) validate() string {
+	if r.Name == "" {
+		return "name is required"
+	}
+	if len(r.Steps) == 0 {
+		return "at least one step is required"
+	}
+	keys := map[string]bool{}
+	for _, step := range r.Steps {
+		if step.Key == "" || step.TemplateAlias == "" {
+			return "every step needs a key and a template_alias"
+		}
+		if step.DelayDays < 0 {
+			return "delay_days can't be negative"
+		}
+		if keys[step.Key] {
+			return "step keys must be unique"
+		}
+		keys[step.Key] = true
+	}
+	return ""
+}
+
+func (w *Waitlist) CreateDripSequence() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		collection := w.scoped(c).Collection("drip_sequences")
+		ctx := context.Background()
+
+		request := dripRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+		if msg := request.validate(); msg != "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		now := time.Now().Unix()
+		sequence := models.DripSequence{
+			Waitlist:  list.Slug,
+			Name:      request.Name,
+			Active:    request.Active,
+			Steps:     request.Steps,
+			StartsAt:  request.StartsAt,
+			CreatedAt: now,
+		}
+		// only entries that sign up from now on are enrolled unless told otherwise
+		if sequence.StartsAt == 0 {
+			sequence.StartsAt = now
+		}
+
+		result, err := collection.InsertOne(ctx, sequence)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to write to database", "message": err.Error()})
+			return
+		}
+		sequence.ID = result.InsertedID.(primitive.ObjectID)
+
+		c.JSON(http.StatusCreated, sequence)
+	}
+}
+
+func (w *Waitlist) GetDripSequences() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+
+		filter := bson.M{}
+		if slug := c.Query("waitlist"); slug != "" {
+			filter["waitlist"] = slug
+		}
+
+		cursor, err := w.scoped(c).Collection("drip_sequences").Find(ctx, filter)
+		if err != nil {
+			log.Println("MongoDb find error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching drip sequences"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		sequences := []models.DripSequence{}
+		if err := cursor.All(ctx, &sequences); err != nil {
+			log.Println("MongoDb decode error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error decoding document"})
+			return
+		}
+
+		c.JSON(http.StatusOK, sequences)
+	}
+}
+
+// Replace the name, steps and active flag of a sequence. Steps already sent keep their history by key.
+func (w *Waitlist) UpdateDripSequence() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		collection := w.scoped(c).Collection("drip_sequences")
+		ctx := context.Background()
+
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid drip sequence id"})
+			return
+		}
+
+		request := dripRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+		if msg := request.validate(); msg != "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		set := bson.M{"name": request.Name, "active": request.Active, "steps": request.Steps}
+		if request.StartsAt > 0 {
+			set["starts_at"] = request.StartsAt
+		}
+
+		sequence := models.DripSequence{}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": set}, opts).Decode(&sequence)
+		if err == mongo.ErrNoDocuments {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Drip sequence not found"})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, sequence)
+	}
+}
+
+func (w *Waitlist) DeleteDripSequence() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid drip sequence id"})
+			return
+		}
+
+		result, err := w.scoped(c).Collection("drip_sequences").DeleteOne(ctx, bson.M{"_id": id})
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+		if result.DeletedCount == 0 {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Drip sequence not found"})
+			return
+		}
+
+		// drop anything still waiting to be sent for this sequence
+		filter := bson.M{"sequence_id": id, "status": models.QUEUED_RECIPIENT_STATUS}
+		update := bson.M{"$set": bson.M{"status": models.CANCELLED_RECIPIENT_STATUS, "updated_at": time.Now().Unix()}}
+		if _, err := w.scoped(c).Collection("drip_messages").UpdateMany(ctx, filter, update); err != nil {
+			log.Println("unable to cancel drip messages:", err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Drip sequence deleted"})
+	}
+}
+
+// Get the queued and sent messages of a sequence, optionally filtered by step and status
+func (w *Waitlist) GetDripMessages() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid drip sequence id"})
+			return
+		}
+
+		filter := bson.M{"sequence_id": id}
+		if step := c.Query("step"); step != "" {
+			filter["step_key"] = step
+		}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+
+		cursor, err := w.scoped(c).Collection("drip_messages").Find(ctx, filter)
+		if err != nil {
+			log.Println("MongoDb find error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching drip messages"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		messages := []models.DripMessage{}
+		if err := cursor.All(ctx, &messages); err != nil {
+			log.Println("MongoDb decode error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error decoding document"})
+			return
+		}
+
+		c.JSON(http.StatusOK, messages)
+	}
+}
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"time"
+	"waitlist/lib/emailclient"
+	"waitlist/lib/tenant"
+	"waitlist/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const dripInterval = 5 * time.Minute
+
+// RunDrips periodically enqueues due drip steps and sends the queue until ctx is done
+func (w *Waitlist) RunDrips(ctx context.Context) {
+	ticker := time.NewTicker(dripInterval)
+	defer ticker.Stop()
+
+	for {
+		w.enqueueDrips(ctx)
+		w.sendDrips(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Waitlist) enqueueDrips(ctx context.Context) {
+	cursor, err := w.db.Collection("drip_sequences").Find(ctx, bson.M{"active": true})
+	if err != nil {
+		log.Println("MongoDb find error:", err)
+		return
+	}
+	sequences := []models.DripSequence{}
+	if err := cursor.All(ctx, &sequences); err != nil {
+		log.Println("MongoDb decode error", err)
+		return
+	}
+
+	for _, sequence := range sequences {
+		for _, step := range sequence.Steps {
+			if err := w.enqueueDripStep(ctx, &sequence, &step); err != nil {
+				log.Println("unable to enqueue drip step", step.Key, err)
+			}
+		}
+	}
+}
+
+// enqueueDripStep queues the step for every matching entry that has not had it yet.
+// The unique key makes repeated or concurrent runs harmless.
+func (w *Waitlist) enqueueDripStep(ctx context.Context, sequence *models.DripSequence, step *models.DripStep) error {
+	db := tenant.Scope(w.db, sequence.TenantID)
+	cutoff := time.Now().AddDate(0, 0, -step.DelayDays).Unix()
+	list, err := w.findWaitlist(ctx, db, sequence.Waitlist)
+	if err != nil {
+		return err
+	}
+
+	match := step.Conditions.Query()
+	match["waitlist"] = list.Slug
+	match["opt_out"] = bson.M{"$exists": false}
+	match["deleted_at"] = bson.M{"$exists": false}
+	match["timestamp"] = bson.M{"$gte": sequence.StartsAt, "$lte": cutoff}
+
+	pipeline := bson.A{
+		bson.M{"$match": match},
+		bson.M{"$lookup": bson.M{
+			"from": "drip_messages",
+			"let":  bson.M{"email": "$email"},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$and": bson.A{
+					bson.M{"$eq": bson.A{"$email", "$$email"}},
+					bson.M{"$eq": bson.A{"$sequence_id", sequence.ID}},
+					bson.M{"$eq": bson.A{"$step_key", step.Key}},
+				}}}},
+				bson.M{"$limit": 1},
+			},
+			"as": "queued",
+		}},
+		bson.M{"$match": bson.M{"queued": bson.M{"$size": 0}}},
+		bson.M{"$project": bson.M{"email": 1}},
+	}
+
+	cursor, err := db.Collection("waitlist").Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	queue := db.Collection("drip_messages")
+	now := time.Now().Unix()
+	for cursor.Next(ctx) {
+		var entry models.WaitlistEntry
+		if err := cursor.Decode(&entry); err != nil {
+			return err
+		}
+
+		drip := models.DripMessage{
+			Key:        sequence.ID.Hex() + ":" + step.Key + ":" + entry.Email,
+			SequenceID: sequence.ID,
+			StepKey:    step.Key,
+			Email:      entry.Email,
+			Message: models.Message{
+				CustomerID: entry.ID.Hex(),
+				AccountID:  list.TenantID,
+				Waitlist:   list.Slug,
+				Sender:     list.SenderEmail,
+				Target:     entry.Email,
+				Type:       models.EMAIL_MESSAGE_TYPE,
+				Title:      sequence.Name,
+				TemplateID: step.TemplateAlias,
+				DataMap:    map[string]string{"Email": entry.Email},
+				Ts:         now,
+			},
+			Status:    models.QUEUED_RECIPIENT_STATUS,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if _, err := queue.InsertOne(ctx, drip); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// sendDrips drains the queue of every organization, claiming each message so
+// replicas never double send. Messages carry their tenant as AccountID.
+func (w *Waitlist) sendDrips(ctx context.Context) {
+	queue := w.db.Collection("drip_messages")
+
+	stale := bson.M{
+		"status":     models.SENDING_RECIPIENT_STATUS,
+		"updated_at": bson.M{"$lt": time.Now().Add(-sendClaimTimeout).Unix()},
+	}
+	requeue := bson.M{"$set": bson.M{"status": models.QUEUED_RECIPIENT_STATUS}}
+	if _, err := queue.UpdateMany(ctx, stale, requeue); err != nil {
+		log.Println("unable to requeue drip messages:", err)
+	}
+
+	for {
+		drip := models.DripMessage{}
+		filter := bson.M{"status": models.QUEUED_RECIPIENT_STATUS}
+		claim := bson.M{"$set": bson.M{"status": models.SENDING_RECIPIENT_STATUS, "updated_at": time.Now().Unix()}}
+		err := queue.FindOneAndUpdate(ctx, filter, claim).Decode(&drip)
+		if err == mongo.ErrNoDocuments {
+			return
+		} else if err != nil {
+			log.Println("unable to claim drip message:", err)
+			return
+		}
+
+		status, sendErr := models.SENT_RECIPIENT_STATUS, w.notifier.Send(&drip.Message)
+		set := bson.M{"updated_at": time.Now().Unix()}
+		if sendErr == emailclient.ErrSuppressed {
+			status = models.SKIPPED_RECIPIENT_STATUS
+		} else if sendErr != nil {
+			status = models.FAILED_RECIPIENT_STATUS
+			set["error"] = sendErr.Error()
+		}
+		set["status"] = status
+
+		if _, err := queue.UpdateOne(ctx, bson.M{"_id": drip.ID}, bson.M{"$set": set}); err != nil {
+			log.Println("unable to update drip message:", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"waitlist/middleware"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const feedHeartbeat = 15 * time.Second
+
+// RunFeed feeds the event stream from Mongo change streams until ctx is done,
+// when the deployment supports them
+func (w *Waitlist) RunFeed(ctx context.Context) {
+	w.feed.Watch(ctx, w.db.Collection("waitlist"))
+}
+
+// Stream signups, confirmations and deletions of the organization as
+// Server-Sent Events, optionally narrowed to one waitlist. Clients resume
+// with the Last-Event-ID header after a reconnect, and get a reset event when
+// they were away too long to catch up.
+func (w *Waitlist) GetFeed() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant := c.GetString(middleware.TenantKey)
+		slug := c.Query("waitlist")
+
+		var lastID uint64
+		if header := c.GetHeader("Last-Event-ID"); header != "" {
+			id, err := strconv.ParseUint(header, 10, 64)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Last-Event-ID must be an event id"})
+				return
+			}
+			lastID = id
+		}
+
+		backlog, reset, events, cancel := w.feed.Subscribe(lastID)
+		defer cancel()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		fmt.Fprint(c.Writer, "retry: 3000\n\n")
+		c.Writer.Flush()
+
+		send := func(event models.Event) bool {
+			if event.TenantID != tenant || (slug != "" && event.Waitlist != slug) {
+				return true
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				return true
+			}
+			if _, err := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+				return false
+			}
+			c.Writer.Flush()
+			return true
+		}
+
+		if reset {
+			// too far behind to resume, the client reloads what it shows
+			fmt.Fprint(c.Writer, "event: reset\ndata: {}\n\n")
+			c.Writer.Flush()
+		}
+		for _, event := range backlog {
+			if !send(event) {
+				return
+			}
+		}
+
+		heartbeat := time.NewTicker(feedHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case event, ok := <-events:
+				if !ok {
+					// fell too far behind, the client reconnects and resumes
+					return
+				}
+				if !send(event) {
+					return
+				}
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(c.Writer, ": heartbeat\n\n"); err != nil {
+					return
+				}
+				c.Writer.Flush()
+			}
+		}
+	}
+}
+
+// emit publishes an event about entry to the feed and queues it for webhooks
+func (w *Waitlist) emit(eventType models.EventType, entry *models.WaitlistEntry) {
+	event := models.Event{
+		Type:     eventType,
+		TenantID: entry.TenantID,
+		Waitlist: entry.Waitlist,
+		Email:    entry.Email,
+		Entry:    entry,
+	}
+	w.feed.Emit(event)
+	w.dispatch(context.Background(), event)
+}
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+	"waitlist/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	maxSignupFields = 20
+	// maxFieldLength caps text values of fields without their own max_length
+	maxFieldLength = 1000
+)
+
+var fieldKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+
+// validateFields checks a field schema and returns a message for the caller
+func validateFields(fields []models.SignupField) string {
+	if len(fields) > maxSignupFields {
+		return "a waitlist can have at most " + strconv.Itoa(maxSignupFields) + " fields"
+	}
+	keys := map[string]bool{}
+	for _, field := range fields {
+		if !fieldKeyPattern.MatchString(field.Key) {
+			return "field keys must be lowercase letters, digits and underscores"
+		}
+		if keys[field.Key] {
+			return "field keys must be unique"
+		}
+		keys[field.Key] = true
+
+		switch field.Type {
+		case models.TEXT_FIELD_TYPE, models.NUMBER_FIELD_TYPE, models.BOOLEAN_FIELD_TYPE:
+		default:
+			return "field " + field.Key + " has an unknown type"
+		}
+		if len(field.Enum) > 0 && field.Type != models.TEXT_FIELD_TYPE {
+			return "only text fields can have an enum"
+		}
+		if field.MaxLength < 0 || field.MaxLength > maxFieldLength {
+			return "max_length must be between 0 and " + strconv.Itoa(maxFieldLength)
+		}
+	}
+	return ""
+}
+
+// signupMetadata validates the submitted values against fields and returns
+// the metadata to store. Unknown keys are rejected.
+func signupMetadata(fields []models.SignupField, values map[string]interface{}) (map[string]interface{}, error) {
+	known := map[string]bool{}
+	metadata := map[string]interface{}{}
+
+	for _, field := range fields {
+		known[field.Key] = true
+		value, ok := values[field.Key]
+		if !ok || value == nil || value == "" {
+			if field.Required {
+				return nil, fmt.Errorf("%s is required", field.Key)
+			}
+			continue
+		}
+
+		switch field.Type {
+		case models.TEXT_FIELD_TYPE:
+			text, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be text", field.Key)
+			}
+			limit := field.MaxLength
+			if limit == 0 {
+				limit = maxFieldLength
+			}
+			if utf8.RuneCountInString(text) > limit {
+				return nil, fmt.Errorf("%s can't be longer than %d characters", field.Key, limit)
+			}
+			if len(field.Enum) > 0 && !contains(field.Enum, text) {
+				return nil, fmt.Errorf("%s must be one of %v", field.Key, field.Enum)
+			}
+		case models.NUMBER_FIELD_TYPE:
+			if _, ok := value.(float64); !ok {
+				return nil, fmt.Errorf("%s must be a number", field.Key)
+			}
+		case models.BOOLEAN_FIELD_TYPE:
+			if _, ok := value.(bool); !ok {
+				return nil, fmt.Errorf("%s must be true or false", field.Key)
+			}
+		}
+		metadata[field.Key] = value
+	}
+
+	for key := range values {
+		if !known[key] {
+			return nil, fmt.Errorf("%s is not a field of this waitlist", key)
+		}
+	}
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	return metadata, nil
+}
+
+// fieldFilter turns fields[key]=value query parameters into a filter on the
+// entry metadata, parsing each value as the type of its field
+func fieldFilter(fields []models.SignupField, query map[string]string) (bson.M, error) {
+	filter := bson.M{}
+	for key, raw := range query {
+		field, ok := findField(fields, key)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a field of this waitlist", key)
+		}
+
+		var value interface{} = raw
+		switch field.Type {
+		case models.NUMBER_FIELD_TYPE:
+			number, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be a number", key)
+			}
+			value = number
+		case models.BOOLEAN_FIELD_TYPE:
+			flag, err := strconv.ParseBool(raw)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be true or false", key)
+			}
+			value = flag
+		}
+		filter["metadata."+key] = value
+	}
+	return filter, nil
+}
+
+func findField(fields []models.SignupField, key string) (models.SignupField, bool) {
+	for _, field := range fields {
+		if field.Key == key {
+			return field, true
+		}
+	}
+	return models.SignupField{}, false
+}
+
+// formatField renders a metadata value for export, leaving missing values empty
+func formatField(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
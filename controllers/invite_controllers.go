@@ -0,0 +1,403 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"waitlist/lib/emailclient"
+	"waitlist/lib/tenant"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// email templates
+	InviteAlias = "waitlist-invite"
+
+	// sms bodies
+	InviteSMS = "You're in! Your invite code is %s. It expires on %s."
+
+	defaultInviteExpiry = 7 * 24 // hours
+	maxWaveSize         = 5000
+)
+
+type waveRequest struct {
+	Count          int                   `json:"count"`
+	Audience       models.AudienceFilter `json:"audience"`
+	ExpiresInHours int64                 `json:"expires_in_hours"`
+}
+
+// Release the next count waiting entries, in queue order, optionally narrowed by an audience filter
+func (w *Waitlist) ReleaseInviteWave() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		request := waveRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+		if request.Count < 1 || request.Count > maxWaveSize {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "count must be between 1 and " + strconv.Itoa(maxWaveSize)})
+			return
+		}
+		if request.ExpiresInHours < 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "expires_in_hours can't be negative"})
+			return
+		}
+
+		request.Audience.Waitlist = waitlistSlug(c)
+		wave := models.InviteWave{
+			Requested: request.Count,
+			Audience:  request.Audience,
+			ExpiresIn: request.ExpiresInHours,
+			Source:    "manual",
+		}
+		if err := w.releaseWave(context.Background(), w.scoped(c), &wave, nil); err != nil {
+			log.Println("unable to release invite wave:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to release invite wave", "wave": wave})
+			return
+		}
+
+		c.JSON(http.StatusCreated, wave)
+	}
+}
+
+func (w *Waitlist) GetInviteWaves() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+		cursor, err := w.scoped(c).Collection("invite_waves").Find(ctx, bson.M{}, opts)
+		if err != nil {
+			log.Println("MongoDb find error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching invite waves"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		waves := []models.InviteWave{}
+		if err := cursor.All(ctx, &waves); err != nil {
+			log.Println("MongoDb decode error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error decoding document"})
+			return
+		}
+
+		c.JSON(http.StatusOK, waves)
+	}
+}
+
+// Get invites, optionally filtered by email, status and wave
+func (w *Waitlist) GetInvites() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+
+		filter := bson.M{}
+		if slug := c.Query("waitlist"); slug != "" {
+			filter["waitlist"] = slug
+		}
+		if email := c.Query("email"); email != "" {
+			filter["email"] = email
+		}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+		if wave := c.Query("wave"); wave != "" {
+			id, err := primitive.ObjectIDFromHex(wave)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid wave id"})
+				return
+			}
+			filter["wave_id"] = id
+		}
+		limit, skip, err := pagination(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit).SetSkip(skip)
+		cursor, err := w.scoped(c).Collection("invites").Find(ctx, filter, opts)
+		if err != nil {
+			log.Println("MongoDb find error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching invites"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		invites := []models.Invite{}
+		if err := cursor.All(ctx, &invites); err != nil {
+			log.Println("MongoDb decode error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error decoding document"})
+			return
+		}
+
+		c.JSON(http.StatusOK, invites)
+	}
+}
+
+// Send an entry a fresh invite code, revoking its open invite once the new one is sent
+func (w *Waitlist) ResendInvite() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		email := c.Param("email")
+
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+		db := w.scoped(c)
+
+		entry := models.WaitlistEntry{}
+		err := db.Collection("waitlist").FindOne(ctx, live(bson.M{"waitlist": list.Slug, "email": email})).Decode(&entry)
+		if err == mongo.ErrNoDocuments {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Email not found"})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+		if entry.Status == models.ACCEPTED_ENTRY_STATUS {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "invite already accepted"})
+			return
+		}
+
+		// the open invite is only revoked once the new code is sent
+		invite, err := w.inviteEntry(ctx, db, list, &entry, primitive.NilObjectID, 0)
+		if err == emailclient.ErrSuppressed {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "address is suppressed, the invite was not sent"})
+			return
+		} else if err != nil {
+			log.Println("unable to resend invite:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to send invite"})
+			return
+		}
+
+		c.JSON(http.StatusOK, invite)
+	}
+}
+
+// releaseWave invites up to wave.Requested waiting entries of the tenant of db
+// in queue order and records the wave. skip, when set, rejects candidates
+// without consuming the quota.
+func (w *Waitlist) releaseWave(ctx context.Context, db *tenant.Database, wave *models.InviteWave, skip func(*models.WaitlistEntry) bool) error {
+	waves := db.Collection("invite_waves")
+	list, err := w.findWaitlist(ctx, db, wave.Audience.Waitlist)
+	if err != nil {
+		return err
+	}
+	wave.Audience.Waitlist = list.Slug
+	if wave.ExpiresIn == 0 {
+		wave.ExpiresIn = inviteExpiry(list)
+	}
+	wave.CreatedAt = time.Now().Unix()
+
+	result, err := waves.InsertOne(ctx, wave)
+	if err != nil {
+		return err
+	}
+	wave.ID = result.InsertedID.(primitive.ObjectID)
+
+	settings, err := w.scoringSettings(ctx, db, list.Slug)
+	if err != nil {
+		return err
+	}
+
+	opts := options.Find().SetSort(queueOrder(settings.Enabled))
+	cursor, err := db.Collection("waitlist").Find(ctx, waitingQuery(wave.Audience), opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for wave.Released < wave.Requested && cursor.Next(ctx) {
+		var entry models.WaitlistEntry
+		if err := cursor.Decode(&entry); err != nil {
+			return err
+		}
+		if skip != nil && skip(&entry) {
+			continue
+		}
+
+		_, err := w.inviteEntry(ctx, db, list, &entry, wave.ID, wave.ExpiresIn)
+		if err == errNotWaiting {
+			// another admin or replica got there first
+			continue
+		} else if err == emailclient.ErrSuppressed {
+			// left waiting, without an invite
+			log.Println("invite not sent, recipient suppressed:", entry.Email)
+			continue
+		} else if err != nil {
+			log.Println("unable to invite", entry.Email, err)
+			continue
+		}
+		wave.Released++
+	}
+
+	wave.CompletedAt = time.Now().Unix()
+	update := bson.M{"$set": bson.M{"released": wave.Released, "completed_at": wave.CompletedAt}}
+	if _, err := waves.UpdateOne(ctx, bson.M{"_id": wave.ID}, update); err != nil {
+		return err
+	}
+	return cursor.Err()
+}
+
+var errNotWaiting = errors.New("entry is no longer waiting")
+
+// inviteEntry moves entry to invited, stores a new invite and sends its code,
+// revoking the codes sent before it once the new one is out. Entries that are
+// not waiting, expired or already invited are left alone. A zero
+// expiresInHours uses the expiry configured on the waitlist. Suppressed
+// addresses are refused with emailclient.ErrSuppressed before anything
+// changes, and when the code can't be sent the entry is put back as it was.
+func (w *Waitlist) inviteEntry(ctx context.Context, db *tenant.Database, list *models.Waitlist, entry *models.WaitlistEntry, waveID primitive.ObjectID, expiresInHours int64) (*models.Invite, error) {
+	now := time.Now()
+	if expiresInHours == 0 {
+		expiresInHours = inviteExpiry(list)
+	}
+
+	suppressed, err := w.suppressions.IsSuppressed(ctx, list.TenantID, entry.Email)
+	if err != nil {
+		return nil, err
+	}
+	if suppressed {
+		return nil, emailclient.ErrSuppressed
+	}
+	previous := *entry
+
+	filter := live(bson.M{"_id": entry.ID, "status": bson.M{"$in": bson.A{
+		nil, models.WAITING_ENTRY_STATUS, models.EXPIRED_ENTRY_STATUS, models.INVITED_ENTRY_STATUS,
+	}}})
+	if waveID != primitive.NilObjectID {
+		// waves only ever admit entries that are still waiting
+		filter["status"] = bson.M{"$in": bson.A{nil, models.WAITING_ENTRY_STATUS}}
+	}
+	update := bson.M{"$set": bson.M{"status": models.INVITED_ENTRY_STATUS, "invited_at": now.Unix()}}
+	result, err := db.Collection("waitlist").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, errNotWaiting
+	}
+	entry.Status = models.INVITED_ENTRY_STATUS
+
+	code, err := newInviteCode()
+	if err != nil {
+		return nil, err
+	}
+	invite := models.Invite{
+		CodeHash:  hashInviteCode(code),
+		EntryID:   entry.ID,
+		Waitlist:  list.Slug,
+		Email:     entry.Email,
+		WaveID:    waveID,
+		Status:    models.SENT_INVITE_STATUS,
+		ExpiresAt: now.Add(time.Duration(expiresInHours) * time.Hour).Unix(),
+		CreatedAt: now.Unix(),
+	}
+	inserted, err := db.Collection("invites").InsertOne(ctx, invite)
+	if err != nil {
+		return nil, err
+	}
+	invite.ID = inserted.InsertedID.(primitive.ObjectID)
+
+	expires := time.Unix(invite.ExpiresAt, 0).UTC().Format("2 January 2006")
+	data := map[string]string{"InviteCode": code, "ExpiresAt": expires}
+	if err := w.sendMsg(list, entry, "waitlist-invite", template(list.Templates.Invite, InviteAlias), data); err != nil {
+		if err := withdrawInvite(ctx, db, &previous, &invite, now.Unix()); err != nil {
+			log.Println("unable to withdraw unsent invite for", entry.Email, err)
+		}
+		*entry = previous
+		return nil, err
+	}
+
+	filter = bson.M{"entry_id": entry.ID, "status": models.SENT_INVITE_STATUS, "_id": bson.M{"$ne": invite.ID}}
+	update = bson.M{"$set": bson.M{"status": models.REVOKED_INVITE_STATUS}}
+	if _, err := db.Collection("invites").UpdateMany(ctx, filter, update); err != nil {
+		log.Println("unable to revoke previous invites for", entry.Email, err)
+	}
+	if entry.Phone != "" {
+		if err := w.sendSMS(list, entry, "waitlist-invite", fmt.Sprintf(InviteSMS, code, expires), code); err != nil {
+			log.Println("unable to send sms:", err)
+		}
+	}
+
+	w.dispatch(ctx, models.Event{
+		Type:     models.INVITE_SENT_EVENT_TYPE,
+		TenantID: list.TenantID,
+		Waitlist: list.Slug,
+		Email:    entry.Email,
+		Entry:    entry,
+		Invite:   &invite,
+	})
+	return &invite, nil
+}
+
+// withdrawInvite revokes an invite whose code never reached the entry and puts
+// the entry back to the status it had before, unless it moved on meanwhile
+func withdrawInvite(ctx context.Context, db *tenant.Database, previous *models.WaitlistEntry, invite *models.Invite, invitedAt int64) error {
+	update := bson.M{"$set": bson.M{"status": models.REVOKED_INVITE_STATUS}}
+	if _, err := db.Collection("invites").UpdateOne(ctx, bson.M{"_id": invite.ID}, update); err != nil {
+		return err
+	}
+	invite.Status = models.REVOKED_INVITE_STATUS
+
+	restore := bson.M{}
+	unset := bson.M{}
+	if previous.Status != "" {
+		restore["status"] = previous.Status
+	} else {
+		unset["status"] = ""
+	}
+	if previous.InvitedAt != 0 {
+		restore["invited_at"] = previous.InvitedAt
+	} else {
+		unset["invited_at"] = ""
+	}
+	update = bson.M{}
+	if len(restore) > 0 {
+		update["$set"] = restore
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	filter := bson.M{"_id": previous.ID, "status": models.INVITED_ENTRY_STATUS, "invited_at": invitedAt}
+	_, err := db.Collection("waitlist").UpdateOne(ctx, filter, update)
+	return err
+}
+
+// inviteExpiry returns how many hours invites of list stay valid
+func inviteExpiry(list *models.Waitlist) int64 {
+	if list.Settings.InviteExpiryHours > 0 {
+		return list.Settings.InviteExpiryHours
+	}
+	return defaultInviteExpiry
+}
+
+// newInviteCode returns a random, human friendly code
+func newInviteCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf), nil
+}
+
+// hashInviteCode returns the stored form of code
+func hashInviteCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToUpper(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+	"waitlist/lib/tenant"
+	"waitlist/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	inviteExpiryInterval = time.Minute
+
+	// what happens to an entry whose invite expired, set with INVITE_EXPIRY_ACTION
+	requeueExpiredInvites = "requeue"
+	resendExpiredInvites  = "resend"
+)
+
+// RunInviteExpiry expires overdue invites until ctx is done. Their entries go back
+// to the pool at their original position, or get a fresh invite when
+// INVITE_EXPIRY_ACTION is "resend".
+func (w *Waitlist) RunInviteExpiry(ctx context.Context) {
+	ticker := time.NewTicker(inviteExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		w.expireInvites(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Waitlist) expireInvites(ctx context.Context) {
+	// expiry runs across every organization, entries are handled in the tenant of their invite
+	invites := w.db.Collection("invites")
+	action := os.Getenv("INVITE_EXPIRY_ACTION")
+	if action == "" {
+		action = requeueExpiredInvites
+	}
+
+	for {
+		// claim one invite at a time so replicas never handle the same one
+		invite := models.Invite{}
+		filter := bson.M{"status": models.SENT_INVITE_STATUS, "expires_at": bson.M{"$lt": time.Now().Unix()}}
+		update := bson.M{"$set": bson.M{"status": models.EXPIRED_INVITE_STATUS}}
+		err := invites.FindOneAndUpdate(ctx, filter, update).Decode(&invite)
+		if err == mongo.ErrNoDocuments {
+			return
+		} else if err != nil {
+			log.Println("unable to expire invite:", err)
+			return
+		}
+
+		if err := w.handleExpiredInvite(ctx, &invite, action); err != nil {
+			log.Println("unable to handle expired invite for", invite.Email, err)
+		}
+	}
+}
+
+func (w *Waitlist) handleExpiredInvite(ctx context.Context, invite *models.Invite, action string) error {
+	db := tenant.Scope(w.db, invite.TenantID)
+	collection := db.Collection("waitlist")
+
+	filter := live(bson.M{"_id": invite.EntryID, "status": models.INVITED_ENTRY_STATUS})
+	update := bson.M{"$set": bson.M{"status": models.EXPIRED_ENTRY_STATUS}}
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil || result.MatchedCount == 0 {
+		// the entry was accepted, deleted or re-invited meanwhile
+		return err
+	}
+
+	if action == resendExpiredInvites {
+		list, err := w.findWaitlist(ctx, db, invite.Waitlist)
+		if err != nil {
+			return err
+		}
+		entry := models.WaitlistEntry{}
+		if err := collection.FindOne(ctx, bson.M{"_id": invite.EntryID}).Decode(&entry); err != nil {
+			return err
+		}
+		_, err = w.inviteEntry(ctx, db, list, &entry, primitive.NilObjectID, 0)
+		return err
+	}
+
+	// back to the pool, keeping the original signup time and so the original position
+	filter = bson.M{"_id": invite.EntryID, "status": models.EXPIRED_ENTRY_STATUS}
+	update = bson.M{"$set": bson.M{"status": models.WAITING_ENTRY_STATUS}, "$unset": bson.M{"invited_at": ""}}
+	_, err = collection.UpdateOne(ctx, filter, update)
+	return err
+}
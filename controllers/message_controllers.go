@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"waitlist/lib/messagelog"
+	"waitlist/middleware"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+var (
+	errInvalidLimit = errors.New("limit must be between 1 and 1000")
+	errInvalidSkip  = errors.New("skip can't be negative")
+)
+
+// Search the message log by waitlist, recipient, type, status and a unix time range (from, to)
+func (w *Waitlist) GetMessages() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := messagelog.Query{
+			Account:   c.GetString(middleware.TenantKey),
+			Waitlist:  c.Query("waitlist"),
+			Recipient: c.Query("recipient"),
+			Type:      models.MessageType(c.Query("type")),
+			Status:    models.MessageStatus(c.Query("status")),
+		}
+
+		var err error
+		if query.From, err = queryInt(c, "from", 0); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "from must be a unix timestamp"})
+			return
+		}
+		if query.To, err = queryInt(c, "to", 0); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "to must be a unix timestamp"})
+			return
+		}
+		if query.Limit, query.Skip, err = pagination(c); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		messages, err := w.messages.Search(context.Background(), query)
+		if err != nil {
+			log.Println("MongoDb find error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching messages"})
+			return
+		}
+
+		c.JSON(http.StatusOK, messages)
+	}
+}
+
+func (w *Waitlist) GetMessage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		message, err := w.messages.Get(context.Background(), c.GetString(middleware.TenantKey), c.Param("id"))
+		if err == messagelog.ErrNotFound {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Message not found"})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, message)
+	}
+}
+
+// queryInt parses an optional integer query parameter
+func queryInt(c *gin.Context, name string, fallback int64) (int64, error) {
+	value := c.Query(name)
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// pagination reads the limit and skip query parameters
+func pagination(c *gin.Context) (limit int64, skip int64, err error) {
+	if limit, err = queryInt(c, "limit", defaultPageSize); err != nil || limit < 1 || limit > maxPageSize {
+		return 0, 0, errInvalidLimit
+	}
+	if skip, err = queryInt(c, "skip", 0); err != nil || skip < 0 {
+		return 0, 0, errInvalidSkip
+	}
+	return limit, skip, nil
+}
@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+	"waitlist/lib/slack"
+	"waitlist/lib/tenant"
+	"waitlist/lib/webhooks"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// defaultMilestones are announced when a waitlist doesn't configure its own
+var defaultMilestones = []int64{1000, 5000, 10000}
+
+type notificationRequest struct {
+	WebhookURL string  `json:"webhook_url"`
+	Milestones []int64 `json:"milestones"`
+	Digest     bool    `json:"digest"`
+	DigestHour int     `json:"digest_hour"`
+	Timezone   string  `json:"timezone"`
+}
+
+// validate checks the webhook URL, thresholds and digest time and returns a message for the caller
+func (r *notificationRequest) validate() string {
+	target, err := url.Parse(r.WebhookURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return "webhook_url must be an absolute http or https URL"
+	}
+	for _, threshold := range r.Milestones {
+		if threshold <= 0 {
+			return "milestones must be positive"
+		}
+	}
+	if r.DigestHour < 0 || r.DigestHour > 23 {
+		return "digest_hour must be between 0 and 23"
+	}
+	if _, err := time.LoadLocation(r.Timezone); err != nil {
+		return "unknown timezone " + r.Timezone
+	}
+	return ""
+}
+
+func (w *Waitlist) GetNotificationSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		settings, err := w.notificationSettings(context.Background(), w.scoped(c), list.Slug)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// Set where notifications of a waitlist are posted, which signup counts are
+// announced and when the daily digest goes out. Milestones already reached
+// are not announced again.
+func (w *Waitlist) UpdateNotificationSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		collection := w.scoped(c).Collection("notification_settings")
+		ctx := context.Background()
+
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		request := notificationRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+		if request.Timezone == "" {
+			request.Timezone = "UTC"
+		}
+		if msg := request.validate(); msg != "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		if request.Milestones == nil {
+			request.Milestones = defaultMilestones
+		}
+		sort.Slice(request.Milestones, func(i, j int) bool { return request.Milestones[i] < request.Milestones[j] })
+
+		now := time.Now()
+		set := bson.M{
+			"webhook_url": request.WebhookURL,
+			"milestones":  request.Milestones,
+			"digest":      request.Digest,
+			"digest_hour": request.DigestHour,
+			"timezone":    request.Timezone,
+			"updated_at":  now.Unix(),
+		}
+		if request.Digest {
+			location, _ := time.LoadLocation(request.Timezone)
+			set["next_digest_at"] = nextDigest(now, request.DigestHour, location).Unix()
+		}
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+		settings := models.NotificationSettings{}
+		err := collection.FindOneAndUpdate(ctx, bson.M{"_id": list.Slug}, bson.M{"$set": set}, opts).Decode(&settings)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// Post a test message to the configured webhook
+func (w *Waitlist) TestNotification() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		settings, err := w.notificationSettings(context.Background(), w.scoped(c), list.Slug)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+		if settings.WebhookURL == "" {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Notifications are not configured"})
+			return
+		}
+
+		text := fmt.Sprintf("Notifications for *%s* are set up.", slack.Escape(list.Name))
+		err = w.slack.Post(settings.WebhookURL, slack.Message{Text: text, Blocks: []slack.Block{slack.Section(text)}})
+		if errors.Is(err, webhooks.ErrPrivateAddress) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "webhook url must be a public address"})
+			return
+		} else if err != nil {
+			// the error can carry details of the receiver's network, only the log gets it
+			log.Println("unable to post test notification for", list.Slug, err)
+			c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": "webhook rejected the message"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Test notification sent"})
+	}
+}
+
+// Get the milestones a waitlist has reached, oldest first
+func (w *Waitlist) GetMilestones() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+
+		opts := options.Find().SetSort(bson.D{{Key: "threshold", Value: 1}})
+		cursor, err := w.scoped(c).Collection("milestones").Find(ctx, bson.M{"waitlist": waitlistSlug(c)}, opts)
+		if err != nil {
+			log.Println("MongoDb find error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching milestones"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		milestones := []models.Milestone{}
+		if err := cursor.All(ctx, &milestones); err != nil {
+			log.Println("MongoDb decode error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error decoding document"})
+			return
+		}
+
+		c.JSON(http.StatusOK, milestones)
+	}
+}
+
+// notificationSettings returns the stored settings, or unconfigured defaults when none exist
+func (w *Waitlist) notificationSettings(ctx context.Context, db *tenant.Database, slug string) (*models.NotificationSettings, error) {
+	settings := models.NotificationSettings{Waitlist: slug, Milestones: defaultMilestones, Timezone: "UTC"}
+	err := db.Collection("notification_settings").FindOne(ctx, bson.M{"_id": slug}).Decode(&settings)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// nextDigest returns the first occurrence of hour in location after now
+func nextDigest(now time.Time, hour int, location *time.Location) time.Time {
+	local := now.In(location)
+	next := time.Date(local.Year(), local.Month(), local.Day(), hour, 0, 0, 0, location)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
@@ -0,0 +1,215 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+	"waitlist/lib/slack"
+	"waitlist/lib/tenant"
+	"waitlist/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const notificationInterval = time.Minute
+
+// RunNotifications announces milestones and posts daily digests until ctx is done
+func (w *Waitlist) RunNotifications(ctx context.Context) {
+	ticker := time.NewTicker(notificationInterval)
+	defer ticker.Stop()
+
+	for {
+		w.announceMilestones(ctx)
+		w.postDigests(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// announceMilestones checks every waitlist with notifications configured, across organizations
+func (w *Waitlist) announceMilestones(ctx context.Context) {
+	filter := bson.M{"webhook_url": bson.M{"$ne": ""}, "milestones.0": bson.M{"$exists": true}}
+	cursor, err := w.db.Collection("notification_settings").Find(ctx, filter)
+	if err != nil {
+		log.Println("unable to read notification settings:", err)
+		return
+	}
+	configured := []models.NotificationSettings{}
+	if err := cursor.All(ctx, &configured); err != nil {
+		log.Println("MongoDb decode error", err)
+		return
+	}
+
+	for i := range configured {
+		w.announceMilestone(ctx, &configured[i])
+	}
+}
+
+// announceMilestone records the thresholds a waitlist has crossed and posts
+// the highest new one. The unique milestone index lets exactly one replica
+// record each threshold, so a milestone is announced at most once even when
+// the post fails.
+func (w *Waitlist) announceMilestone(ctx context.Context, settings *models.NotificationSettings) {
+	db := tenant.Scope(w.db, settings.TenantID)
+	milestones := db.Collection("milestones")
+
+	cursor, err := milestones.Find(ctx, bson.M{"waitlist": settings.Waitlist})
+	if err != nil {
+		log.Println("MongoDb find error:", err)
+		return
+	}
+	recorded := []models.Milestone{}
+	if err := cursor.All(ctx, &recorded); err != nil {
+		log.Println("MongoDb decode error", err)
+		return
+	}
+	reached := map[int64]bool{}
+	for _, milestone := range recorded {
+		reached[milestone.Threshold] = true
+	}
+	pending := []int64{}
+	for _, threshold := range settings.Milestones {
+		if !reached[threshold] {
+			pending = append(pending, threshold)
+		}
+	}
+	// nothing left to announce, so no need to count
+	if len(pending) == 0 {
+		return
+	}
+
+	count, err := db.Collection("waitlist").CountDocuments(ctx, live(bson.M{"waitlist": settings.Waitlist}))
+	if err != nil {
+		log.Println("unable to count waitlist entries:", err)
+		return
+	}
+
+	var crossed int64
+	now := time.Now().Unix()
+	for _, threshold := range pending {
+		if threshold > count {
+			continue
+		}
+		milestone := models.Milestone{Waitlist: settings.Waitlist, Threshold: threshold, Count: count, ReachedAt: now}
+		if _, err := milestones.InsertOne(ctx, milestone); mongo.IsDuplicateKeyError(err) {
+			continue
+		} else if err != nil {
+			log.Println("unable to record milestone:", err)
+			return
+		}
+		if threshold > crossed {
+			crossed = threshold
+		}
+	}
+	if crossed == 0 {
+		return
+	}
+
+	name := w.waitlistName(ctx, db, settings.Waitlist)
+	text := fmt.Sprintf(":tada: *%s* has passed %s signups, %s so far!", slack.Escape(name), formatCount(crossed), formatCount(count))
+	if err := w.slack.Post(settings.WebhookURL, slack.Message{Text: text, Blocks: []slack.Block{slack.Section(text)}}); err != nil {
+		log.Println("unable to post milestone for", settings.Waitlist, err)
+	}
+}
+
+// postDigests posts the daily digest of every waitlist whose digest is due, across organizations
+func (w *Waitlist) postDigests(ctx context.Context) {
+	filter := bson.M{
+		"digest":         true,
+		"webhook_url":    bson.M{"$ne": ""},
+		"next_digest_at": bson.M{"$lte": time.Now().Unix()},
+	}
+	cursor, err := w.db.Collection("notification_settings").Find(ctx, filter)
+	if err != nil {
+		log.Println("unable to read notification settings:", err)
+		return
+	}
+	due := []models.NotificationSettings{}
+	if err := cursor.All(ctx, &due); err != nil {
+		log.Println("MongoDb decode error", err)
+		return
+	}
+
+	for i := range due {
+		w.postDigest(ctx, &due[i])
+	}
+}
+
+// postDigest summarises the signups of the 24 hours before the scheduled digest time
+func (w *Waitlist) postDigest(ctx context.Context, settings *models.NotificationSettings) {
+	db := tenant.Scope(w.db, settings.TenantID)
+	location, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+
+	// claim the digest by moving next_digest_at forward, so replicas post it once
+	next := nextDigest(time.Now(), settings.DigestHour, location)
+	filter := bson.M{"_id": settings.Waitlist, "next_digest_at": settings.NextDigestAt}
+	result, err := db.Collection("notification_settings").UpdateOne(ctx, filter, bson.M{"$set": bson.M{"next_digest_at": next.Unix()}})
+	if err != nil || result.ModifiedCount == 0 {
+		return
+	}
+
+	entries := db.Collection("waitlist")
+	until := time.Unix(settings.NextDigestAt, 0)
+	window := live(bson.M{
+		"waitlist":  settings.Waitlist,
+		"timestamp": bson.M{"$gte": until.AddDate(0, 0, -1).Unix(), "$lt": until.Unix()},
+	})
+	signups, err := entries.CountDocuments(ctx, window)
+	if err != nil {
+		log.Println("unable to count waitlist entries:", err)
+		return
+	}
+	window["confirmed_at"] = bson.M{"$gt": 0}
+	confirmed, err := entries.CountDocuments(ctx, window)
+	if err != nil {
+		log.Println("unable to count waitlist entries:", err)
+		return
+	}
+	total, err := entries.CountDocuments(ctx, live(bson.M{"waitlist": settings.Waitlist}))
+	if err != nil {
+		log.Println("unable to count waitlist entries:", err)
+		return
+	}
+
+	name := w.waitlistName(ctx, db, settings.Waitlist)
+	summary := fmt.Sprintf("*%s* new signups in the last 24 hours, %s of them confirmed.\n*%s* on the list in total.",
+		formatCount(signups), formatCount(confirmed), formatCount(total))
+	message := slack.Message{
+		Text:   fmt.Sprintf("Daily digest for %s: %s new signups", name, formatCount(signups)),
+		Blocks: []slack.Block{slack.Header("Daily digest: " + name), slack.Section(summary)},
+	}
+	if err := w.slack.Post(settings.WebhookURL, message); err != nil {
+		log.Println("unable to post digest for", settings.Waitlist, err)
+	}
+}
+
+// waitlistName returns the display name of a waitlist, falling back to its slug
+func (w *Waitlist) waitlistName(ctx context.Context, db *tenant.Database, slug string) string {
+	list, err := w.findWaitlist(ctx, db, slug)
+	if err != nil || list.Name == "" {
+		return slug
+	}
+	return list.Name
+}
+
+// formatCount renders n with thousands separators, 12345 as 12,345
+func formatCount(n int64) string {
+	if n < 0 {
+		return "-" + formatCount(-n)
+	}
+	digits := strconv.FormatInt(n, 10)
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + "," + digits[i:]
+	}
+	return digits
+}
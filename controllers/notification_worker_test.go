@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"waitlist/lib/slack"
+	"waitlist/lib/tenant"
+	"waitlist/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testDatabase returns an empty database on the server at TEST_DB_URI,
+// dropped when the test ends. Tests needing one are skipped without it.
+func testDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("TEST_DB_URI")
+	if uri == "" {
+		t.Skip("TEST_DB_URI not set")
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connecting to %s: %v", uri, err)
+	}
+	database := client.Database(fmt.Sprintf("waitlist_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		database.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	return database
+}
+
+// slackReceiver records the messages posted to it
+type slackReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	messages []slack.Message
+}
+
+func newSlackReceiver(t *testing.T) *slackReceiver {
+	receiver := &slackReceiver{}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		message := slack.Message{}
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			t.Errorf("decoding message: %v", err)
+		}
+		receiver.mu.Lock()
+		receiver.messages = append(receiver.messages, message)
+		receiver.mu.Unlock()
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver
+}
+
+func (r *slackReceiver) posted() []slack.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]slack.Message{}, r.messages...)
+}
+
+// notificationFixture stores a waitlist of org with count entries and its notification settings
+func notificationFixture(t *testing.T, database *mongo.Database, count int, settings models.NotificationSettings) {
+	t.Helper()
+	ctx := context.Background()
+	db := tenant.Scope(database, "org")
+
+	if _, err := database.Collection("milestones").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "waitlist", Value: 1}, {Key: "threshold", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		t.Fatalf("creating milestone index: %v", err)
+	}
+	if _, err := db.Collection("waitlists").InsertOne(ctx, models.Waitlist{Slug: "launch", Name: "Launch"}); err != nil {
+		t.Fatalf("inserting waitlist: %v", err)
+	}
+	now := time.Now().Unix()
+	for i := 0; i < count; i++ {
+		entry := models.WaitlistEntry{Waitlist: "launch", Email: fmt.Sprintf("user%d@example.com", i), Timestamp: now - 3600}
+		if _, err := db.Collection("waitlist").InsertOne(ctx, entry); err != nil {
+			t.Fatalf("inserting entry: %v", err)
+		}
+	}
+	settings.Waitlist = "launch"
+	if _, err := db.Collection("notification_settings").InsertOne(ctx, settings); err != nil {
+		t.Fatalf("inserting settings: %v", err)
+	}
+}
+
+func TestMilestoneAnnouncedOnce(t *testing.T) {
+	database := testDatabase(t)
+	receiver := newSlackReceiver(t)
+	notificationFixture(t, database, 3, models.NotificationSettings{WebhookURL: receiver.URL, Milestones: []int64{1, 2, 10}})
+
+	w := &Waitlist{db: database, slack: slack.New(receiver.Client())}
+	ctx := context.Background()
+	w.announceMilestones(ctx)
+	w.announceMilestones(ctx)
+
+	posted := receiver.posted()
+	if len(posted) != 1 {
+		t.Fatalf("posted %d messages, want 1", len(posted))
+	}
+	if !strings.Contains(posted[0].Text, "passed 2 signups") {
+		t.Errorf("posted %q, want the highest milestone crossed", posted[0].Text)
+	}
+}
+
+func TestDigestClaimedOnce(t *testing.T) {
+	database := testDatabase(t)
+	receiver := newSlackReceiver(t)
+	due := time.Now().Add(-time.Minute).Unix()
+	notificationFixture(t, database, 2, models.NotificationSettings{
+		WebhookURL:   receiver.URL,
+		Digest:       true,
+		DigestHour:   9,
+		Timezone:     "UTC",
+		NextDigestAt: due,
+	})
+
+	w := &Waitlist{db: database, slack: slack.New(receiver.Client())}
+	ctx := context.Background()
+	w.postDigests(ctx)
+	w.postDigests(ctx)
+
+	// a replica that read the settings before the first claim
+	stale := models.NotificationSettings{Waitlist: "launch", TenantID: "org", WebhookURL: receiver.URL, Digest: true, DigestHour: 9, Timezone: "UTC", NextDigestAt: due}
+	w.postDigest(ctx, &stale)
+
+	posted := receiver.posted()
+	if len(posted) != 1 {
+		t.Fatalf("posted %d digests, want 1", len(posted))
+	}
+	if !strings.Contains(posted[0].Text, "2 new signups") {
+		t.Errorf("posted %q, want the signups of the day", posted[0].Text)
+	}
+}
@@ -0,0 +1,256 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+	"waitlist/lib/tenant"
+	"waitlist/middleware"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type organizationRequest struct {
+	Name          string `json:"name"`
+	SenderEmail   string `json:"sender_email"`
+	PostmarkToken string `json:"postmark_token"`
+}
+
+type memberRequest struct {
+	Email string `json:"email"`
+}
+
+// Get the organizations the signed in admin belongs to
+func (w *Waitlist) GetOrganizations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+
+		admin, ok := w.currentAdmin(c)
+		if !ok {
+			return
+		}
+
+		filter := bson.M{"_id": bson.M{"$in": admin.Organizations}}
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+		cursor, err := w.db.Collection("organizations").Find(ctx, filter, opts)
+		if err != nil {
+			log.Println("MongoDb find error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching organizations"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		organizations := []models.Organization{}
+		if err := cursor.All(ctx, &organizations); err != nil {
+			log.Println("MongoDb decode error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error decoding document"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"active": c.GetString(middleware.TenantKey), "organizations": organizations})
+	}
+}
+
+// Create an organization, make the signed in admin its first member and switch to it
+func (w *Waitlist) CreateOrganization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+
+		request := organizationRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+		if request.Name == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+			return
+		}
+
+		organization := models.Organization{
+			ID:            primitive.NewObjectID().Hex(),
+			Name:          request.Name,
+			SenderEmail:   request.SenderEmail,
+			PostmarkToken: request.PostmarkToken,
+			CreatedAt:     time.Now().Unix(),
+		}
+		if _, err := w.db.Collection("organizations").InsertOne(ctx, organization); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to write to database", "message": err.Error()})
+			return
+		}
+
+		email := c.GetString(middleware.EmailKey)
+		if err := w.addMember(ctx, organization.ID, email); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		token, err := w.auth.GenerateJWT(email, organization.ID)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to generate token"})
+			return
+		}
+		c.Header("Authorization", token)
+
+		c.JSON(http.StatusCreated, organization)
+	}
+}
+
+// Update the name, sender identity and Postmark server token of an organization.
+// An empty token keeps the current one.
+func (w *Waitlist) UpdateOrganization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		id := c.Param("id")
+
+		if !w.requireMembership(c, id) {
+			return
+		}
+
+		request := organizationRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+		if request.Name == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+			return
+		}
+
+		set := bson.M{"name": request.Name, "sender_email": request.SenderEmail}
+		if request.PostmarkToken != "" {
+			set["postmark_token"] = request.PostmarkToken
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+
+		before := models.Organization{}
+		err := w.db.Collection("organizations").FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": set}, opts).Decode(&before)
+		if err == mongo.ErrNoDocuments {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Organization not found"})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+		w.organizations.Invalidate(id)
+
+		organization := before
+		organization.Name = request.Name
+		organization.SenderEmail = request.SenderEmail
+		if request.PostmarkToken != "" {
+			organization.PostmarkToken = request.PostmarkToken
+		}
+		middleware.AuditState(c, before, organization)
+
+		c.JSON(http.StatusOK, organization)
+	}
+}
+
+// Add an existing admin to an organization the signed in admin belongs to
+func (w *Waitlist) AddOrganizationMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		id := c.Param("id")
+
+		if !w.requireMembership(c, id) {
+			return
+		}
+
+		request := memberRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+
+		err := w.addMember(ctx, id, request.Email)
+		if err == mongo.ErrNoDocuments {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Admin not found"})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+		middleware.AuditTarget(c, "organization="+id+" "+middleware.AuditHash("member", request.Email))
+
+		c.JSON(http.StatusOK, gin.H{"message": "Admin added to organization"})
+	}
+}
+
+// Switch the active organization, returning a new token in the Authorization header
+func (w *Waitlist) SwitchOrganization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		if !w.requireMembership(c, id) {
+			return
+		}
+
+		token, err := w.auth.GenerateJWT(c.GetString(middleware.EmailKey), id)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to generate token"})
+			return
+		}
+		c.Header("Authorization", token)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Switched organization", "active": id})
+	}
+}
+
+// scoped returns the database of the organization the request acts for
+func (w *Waitlist) scoped(c *gin.Context) *tenant.Database {
+	return tenant.Scope(w.db, c.GetString(middleware.TenantKey))
+}
+
+// currentAdmin loads the signed in admin, aborting when it no longer exists
+func (w *Waitlist) currentAdmin(c *gin.Context) (*models.SiginDetails, bool) {
+	admin := models.SiginDetails{}
+	err := w.db.Collection("admin").FindOne(context.Background(), bson.M{"email": c.GetString(middleware.EmailKey)}).Decode(&admin)
+	if err == mongo.ErrNoDocuments {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "admin no longer exists"})
+		return nil, false
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+		return nil, false
+	}
+	return &admin, true
+}
+
+// requireMembership aborts unless the signed in admin belongs to organization id
+func (w *Waitlist) requireMembership(c *gin.Context, id string) bool {
+	admin, ok := w.currentAdmin(c)
+	if !ok {
+		return false
+	}
+	if !contains(admin.Organizations, id) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "not a member of this organization"})
+		return false
+	}
+	return true
+}
+
+// addMember adds organization to the admin with the given email
+func (w *Waitlist) addMember(ctx context.Context, organization string, email string) error {
+	update := bson.M{"$addToSet": bson.M{"organizations": organization}}
+	result, err := w.db.Collection("admin").UpdateOne(ctx, bson.M{"email": email}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
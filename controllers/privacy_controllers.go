@@ -0,0 +1,468 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"waitlist/lib/emailclient"
+	"waitlist/lib/messagelog"
+	"waitlist/lib/tenant"
+	"waitlist/middleware"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// email templates
+	PrivacyAlias = "privacy-request"
+
+	privacyRequestExpiry = 24 * time.Hour
+)
+
+// collections holding data about an email address, in erasure order. The
+// entries go last so a failed erasure can still be retried from the link.
+var subjectCollections = []string{"invites", "campaign_recipients", "drip_messages", "webhook_deliveries", "bulk_items", "referral_rewards", "waitlist"}
+
+type privacyRequest struct {
+	Email string                    `json:"email"`
+	Type  models.PrivacyRequestType `json:"type"`
+}
+
+// subjectData is everything held about one email address in an organization
+type subjectData struct {
+	Email              string                     `json:"email"`
+	GeneratedAt        int64                      `json:"generated_at"`
+	Entries            []models.WaitlistEntry     `json:"waitlist_entries"`
+	Invites            []models.Invite            `json:"invites"`
+	Messages           []models.Message           `json:"messages"`
+	CampaignRecipients []models.CampaignRecipient `json:"campaign_deliveries"`
+	DripMessages       []models.DripMessage       `json:"drip_messages"`
+	WebhookDeliveries  []models.WebhookDelivery   `json:"webhook_deliveries"`
+	ReferralRewards    []models.ReferralReward    `json:"referral_rewards"`
+	Suppressions       []models.Suppression       `json:"suppressions"`
+	PrivacyRequests    []models.PrivacyRequest    `json:"privacy_requests"`
+}
+
+// RequestPrivacy starts a data access or erasure request. A link is emailed to
+// the address and nothing happens until it is followed. The answer is the same
+// whether or not we hold data, so the endpoint can't be used to probe the list.
+func (w *Waitlist) RequestPrivacy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+
+		request := privacyRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+		if request.Email == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+			return
+		}
+		if request.Type != models.ACCESS_PRIVACY_REQUEST_TYPE && request.Type != models.ERASURE_PRIVACY_REQUEST_TYPE {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "type must be access or erasure"})
+			return
+		}
+		list, ok := w.loadPublicWaitlist(c)
+		if !ok {
+			return
+		}
+		db := w.scoped(c)
+
+		token, err := newPrivacyToken()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to generate token"})
+			return
+		}
+		now := time.Now()
+		record := models.PrivacyRequest{
+			Waitlist:  list.Slug,
+			Email:     request.Email,
+			EmailHash: hashEmail(request.Email),
+			Type:      request.Type,
+			Status:    models.PENDING_PRIVACY_REQUEST_STATUS,
+			TokenHash: hashPrivacyToken(token),
+			ExpiresAt: now.Add(privacyRequestExpiry).Unix(),
+			CreatedAt: now.Unix(),
+			Trail:     []models.PrivacyEvent{{Action: "requested", IP: c.ClientIP(), Timestamp: now.Unix()}},
+		}
+
+		// the link goes to an address we actually hold, under any waitlist of the organization
+		entry := models.WaitlistEntry{}
+		err = db.Collection("waitlist").FindOne(ctx, bson.M{"email": request.Email}).Decode(&entry)
+		if err != nil && err != mongo.ErrNoDocuments {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+		suppressed, err := w.suppressions.IsSuppressed(ctx, list.TenantID, request.Email)
+		if err != nil {
+			log.Println("unable to check suppression for", request.Email, err)
+		}
+
+		switch {
+		case entry.Email == "":
+			record.Trail = append(record.Trail, models.PrivacyEvent{Action: "skipped", Detail: "no data held for this address", Timestamp: now.Unix()})
+		case suppressed:
+			record.Trail = append(record.Trail, models.PrivacyEvent{Action: "skipped", Detail: "address is suppressed, no link was sent", Timestamp: now.Unix()})
+		default:
+			action := "export"
+			if request.Type == models.ERASURE_PRIVACY_REQUEST_TYPE {
+				action = ""
+			}
+			data := map[string]string{
+				"Link":      w.signer.PrivacyURL(action, token),
+				"Type":      string(request.Type),
+				"ExpiresAt": time.Unix(record.ExpiresAt, 0).UTC().Format("2 January 2006 15:04 MST"),
+			}
+			if err := w.sendMsg(list, &entry, "privacy-request", PrivacyAlias, data); err != nil && err != emailclient.ErrSuppressed {
+				log.Println("unable to send privacy request email:", err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "Unable to send email"})
+				return
+			}
+			record.Trail = append(record.Trail, models.PrivacyEvent{Action: "link_sent", Timestamp: now.Unix()})
+		}
+
+		if _, err := db.Collection("privacy_requests").InsertOne(ctx, record); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to write to database", "message": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"message": "If we hold data for this address, a verification link has been emailed to it"})
+	}
+}
+
+// Check a privacy link without acting on it, so link scanners can't erase anything
+func (w *Waitlist) GetPrivacyRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		request, ok := w.loadPrivacyRequest(c)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, request)
+	}
+}
+
+// Download everything held about the address of an access request as JSON.
+// The link can be used again until it expires.
+func (w *Waitlist) ExportPrivacyData() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+
+		request, ok := w.loadPrivacyRequest(c)
+		if !ok {
+			return
+		}
+		if request.Type != models.ACCESS_PRIVACY_REQUEST_TYPE {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "this link is not for a data access request"})
+			return
+		}
+
+		data, err := w.subjectData(ctx, w.scoped(c), request.TenantID, request.Email)
+		if err != nil {
+			log.Println("unable to collect subject data:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		now := time.Now().Unix()
+		update := bson.M{
+			"$set":  bson.M{"status": models.COMPLETED_PRIVACY_REQUEST_STATUS, "completed_at": now},
+			"$push": bson.M{"trail": models.PrivacyEvent{Action: "exported", IP: c.ClientIP(), Timestamp: now}},
+		}
+		if _, err := w.scoped(c).Collection("privacy_requests").UpdateOne(ctx, bson.M{"_id": request.ID}, update); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		c.Header("Content-Disposition", `attachment; filename="data-`+request.EmailHash[:12]+`.json"`)
+		c.IndentedJSON(http.StatusOK, data)
+	}
+}
+
+// Erase everything held about the address of an erasure request, across every
+// waitlist of the organization. Suppressions of the address are kept so it is
+// never emailed again, and the request keeps only the hash of the address.
+func (w *Waitlist) ErasePrivacyData() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+
+		request, ok := w.loadPrivacyRequest(c)
+		if !ok {
+			return
+		}
+		db := w.scoped(c)
+		if request.Type != models.ERASURE_PRIVACY_REQUEST_TYPE {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "this link is not for an erasure request"})
+			return
+		}
+
+		// claim the request so a replayed link can't run the erasure twice
+		now := time.Now().Unix()
+		filter := bson.M{"_id": request.ID, "status": models.PENDING_PRIVACY_REQUEST_STATUS}
+		claim := bson.M{"$set": bson.M{"status": models.COMPLETED_PRIVACY_REQUEST_STATUS, "completed_at": now}}
+		result, err := db.Collection("privacy_requests").UpdateOne(ctx, filter, claim)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+		if result.ModifiedCount == 0 {
+			c.AbortWithStatusJSON(http.StatusGone, gin.H{"error": "this request has already been completed"})
+			return
+		}
+
+		erased, eraseErr := w.eraseSubject(ctx, db, request.TenantID, request.Email)
+		trail := models.PrivacyEvent{Action: "erased", IP: c.ClientIP(), Timestamp: time.Now().Unix()}
+		update := bson.M{"$set": bson.M{"erased": erased}, "$push": bson.M{"trail": &trail}}
+		if eraseErr != nil {
+			// reopen the request so the link can be followed again
+			log.Println("unable to erase subject data:", eraseErr)
+			trail.Action, trail.Detail = "erasure_failed", eraseErr.Error()
+			update["$set"] = bson.M{"erased": erased, "status": models.PENDING_PRIVACY_REQUEST_STATUS}
+			update["$unset"] = bson.M{"completed_at": ""}
+		}
+		if _, err := db.Collection("privacy_requests").UpdateOne(ctx, bson.M{"_id": request.ID}, update); err != nil {
+			log.Println("unable to update privacy request:", err)
+		}
+		if eraseErr != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Your data has been erased", "erased": erased})
+	}
+}
+
+// Get the privacy requests of the organization, newest first, optionally
+// filtered by type, status and email address
+func (w *Waitlist) GetPrivacyRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+
+		filter := bson.M{}
+		if kind := c.Query("type"); kind != "" {
+			filter["type"] = kind
+		}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+		// erased requests only keep the hash of the address
+		if email := c.Query("email"); email != "" {
+			filter["email_hash"] = hashEmail(email)
+		}
+		limit, skip, err := pagination(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit).SetSkip(skip)
+		cursor, err := w.scoped(c).Collection("privacy_requests").Find(ctx, filter, opts)
+		if err != nil {
+			log.Println("MongoDb find error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching privacy requests"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		requests := []models.PrivacyRequest{}
+		if err := cursor.All(ctx, &requests); err != nil {
+			log.Println("MongoDb decode error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error decoding document"})
+			return
+		}
+
+		c.JSON(http.StatusOK, requests)
+	}
+}
+
+// loadPrivacyRequest finds the request of the token in the link and scopes the
+// rest of the request to its organization. Tokens are random, so the lookup
+// runs across organizations like invite codes. The first use of a link
+// verifies the request.
+func (w *Waitlist) loadPrivacyRequest(c *gin.Context) (*models.PrivacyRequest, bool) {
+	ctx := context.Background()
+	token := c.Query("token")
+	if token == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "invalid privacy link"})
+		return nil, false
+	}
+
+	request := models.PrivacyRequest{}
+	err := w.db.Collection("privacy_requests").FindOne(ctx, bson.M{"token_hash": hashPrivacyToken(token)}).Decode(&request)
+	if err == mongo.ErrNoDocuments {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "invalid privacy link"})
+		return nil, false
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+		return nil, false
+	}
+	if time.Now().Unix() > request.ExpiresAt {
+		c.AbortWithStatusJSON(http.StatusGone, gin.H{"error": "this link has expired, please make a new request"})
+		return nil, false
+	}
+	if request.Email == "" {
+		c.AbortWithStatusJSON(http.StatusGone, gin.H{"error": "this request has already been completed"})
+		return nil, false
+	}
+	c.Set(middleware.TenantKey, request.TenantID)
+
+	if request.VerifiedAt == 0 {
+		now := time.Now().Unix()
+		update := bson.M{
+			"$set":  bson.M{"verified_at": now},
+			"$push": bson.M{"trail": models.PrivacyEvent{Action: "verified", IP: c.ClientIP(), Timestamp: now}},
+		}
+		if _, err := w.scoped(c).Collection("privacy_requests").UpdateOne(ctx, bson.M{"_id": request.ID, "verified_at": bson.M{"$exists": false}}, update); err != nil {
+			log.Println("unable to verify privacy request:", err)
+		}
+		request.VerifiedAt = now
+	}
+	return &request, true
+}
+
+// subjectData collects every record the organization holds about email
+func (w *Waitlist) subjectData(ctx context.Context, db *tenant.Database, account string, email string) (*subjectData, error) {
+	data := subjectData{Email: email, GeneratedAt: time.Now().Unix()}
+	byEmail := bson.M{"email": email}
+
+	if err := findAll(ctx, db.Collection("waitlist"), byEmail, &data.Entries); err != nil {
+		return nil, err
+	}
+	if err := findAll(ctx, db.Collection("invites"), byEmail, &data.Invites); err != nil {
+		return nil, err
+	}
+	if err := findAll(ctx, db.Collection("campaign_recipients"), byEmail, &data.CampaignRecipients); err != nil {
+		return nil, err
+	}
+	if err := findAll(ctx, db.Collection("drip_messages"), byEmail, &data.DripMessages); err != nil {
+		return nil, err
+	}
+	if err := findAll(ctx, db.Collection("webhook_deliveries"), byEmail, &data.WebhookDeliveries); err != nil {
+		return nil, err
+	}
+	if err := findAll(ctx, db.Collection("referral_rewards"), byEmail, &data.ReferralRewards); err != nil {
+		return nil, err
+	}
+	if err := findAll(ctx, db.Collection("suppressions"), bson.M{"value": strings.ToLower(email)}, &data.Suppressions); err != nil {
+		return nil, err
+	}
+	if err := findAll(ctx, db.Collection("privacy_requests"), bson.M{"email_hash": hashEmail(email)}, &data.PrivacyRequests); err != nil {
+		return nil, err
+	}
+
+	data.Messages = []models.Message{}
+	for _, target := range subjectTargets(email, data.Entries) {
+		messages, err := w.messages.Search(ctx, messagelog.Query{Account: account, Recipient: target})
+		if err != nil {
+			return nil, err
+		}
+		data.Messages = append(data.Messages, messages...)
+	}
+	return &data, nil
+}
+
+// eraseSubject deletes every record the organization holds about email and
+// returns how many documents went from each collection
+func (w *Waitlist) eraseSubject(ctx context.Context, db *tenant.Database, account string, email string) (map[string]int64, error) {
+	erased := map[string]int64{}
+	byEmail := bson.M{"email": email}
+
+	// phone numbers are only known from the entries, read them before they go
+	entries := []models.WaitlistEntry{}
+	if err := findAll(ctx, db.Collection("waitlist"), byEmail, &entries); err != nil {
+		return erased, err
+	}
+	count, err := w.messages.Erase(ctx, account, subjectTargets(email, entries))
+	if err != nil {
+		return erased, err
+	}
+	erased["messages"] = count
+
+	// signups the address referred stay, without the link back to it
+	ids := bson.A{}
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+	if len(ids) > 0 {
+		unlink := bson.M{"$unset": bson.M{"referred_by": ""}}
+		if _, err := db.Collection("waitlist").UpdateMany(ctx, bson.M{"referred_by": bson.M{"$in": ids}}, unlink); err != nil {
+			return erased, err
+		}
+	}
+
+	for _, name := range subjectCollections {
+		result, err := db.Collection(name).DeleteMany(ctx, byEmail)
+		if err != nil {
+			return erased, err
+		}
+		erased[name] = result.DeletedCount
+	}
+
+	// bulk jobs keep their other targets
+	result, err := db.Collection("bulk_jobs").UpdateMany(ctx, bson.M{"emails": email}, bson.M{"$pull": bson.M{"emails": email}})
+	if err != nil {
+		return erased, err
+	}
+	erased["bulk_jobs"] = result.ModifiedCount
+
+	// requests keep their trail, without the address
+	update := bson.M{"$unset": bson.M{"email": ""}}
+	result, err = db.Collection("privacy_requests").UpdateMany(ctx, bson.M{"email_hash": hashEmail(email)}, update)
+	if err != nil {
+		return erased, err
+	}
+	erased["privacy_requests"] = result.ModifiedCount
+
+	return erased, nil
+}
+
+// subjectTargets returns the addresses messages to email may have been sent to
+func subjectTargets(email string, entries []models.WaitlistEntry) []string {
+	targets := []string{email}
+	for _, entry := range entries {
+		if entry.Phone != "" && !contains(targets, entry.Phone) {
+			targets = append(targets, entry.Phone)
+		}
+	}
+	return targets
+}
+
+// findAll decodes every document of collection matching filter into results
+func findAll(ctx context.Context, collection *tenant.Collection, filter interface{}, results interface{}) error {
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, results)
+}
+
+// newPrivacyToken returns the random secret of a privacy link
+func newPrivacyToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// hashPrivacyToken returns the stored form of token
+func hashPrivacyToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// hashEmail returns the stored form of an erased address
+func hashEmail(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(sum[:])
+}
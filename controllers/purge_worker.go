@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const purgeInterval = time.Hour
+
+// RunPurge permanently removes deleted entries whose retention window has
+// ended, across organizations, until ctx is done
+func (w *Waitlist) RunPurge(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		filter := bson.M{"deleted_at": bson.M{"$exists": true}, "purge_at": bson.M{"$lte": time.Now().Unix()}}
+		result, err := w.db.Collection("waitlist").DeleteMany(ctx, filter)
+		if err != nil {
+			log.Println("unable to purge deleted entries:", err)
+		} else if result.DeletedCount > 0 {
+			log.Println("purged deleted entries:", result.DeletedCount)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
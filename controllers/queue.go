@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"context"
+	"waitlist/lib/tenant"
+	"waitlist/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// queueOrder is the order entries are admitted off the waitlist: entries with
+// a priority tag first, then by score when scored, then first come first served
+func queueOrder(scored bool) bson.D {
+	order := bson.D{{Key: "priority", Value: -1}}
+	if scored {
+		order = append(order, bson.E{Key: "score", Value: -1})
+	}
+	return append(order, bson.E{Key: "timestamp", Value: 1}, bson.E{Key: "_id", Value: 1})
+}
+
+// aheadQuery matches the entries sorted before entry by queueOrder(scored)
+func aheadQuery(entry *models.WaitlistEntry, scored bool) bson.M {
+	ahead := bson.A{}
+	samePriority := bson.M{"priority": bson.M{"$ne": true}}
+	if entry.Priority {
+		samePriority = bson.M{"priority": true}
+	} else {
+		ahead = append(ahead, bson.M{"priority": true})
+	}
+
+	keys := bson.D{{Key: "timestamp", Value: entry.Timestamp}, {Key: "_id", Value: entry.ID}}
+	if scored {
+		keys = append(bson.D{{Key: "score", Value: entry.Score}}, keys...)
+	}
+	// ahead on a key when tied on every key before it
+	for i, key := range keys {
+		clause := bson.M{}
+		for k, v := range samePriority {
+			clause[k] = v
+		}
+		for _, tied := range keys[:i] {
+			clause[tied.Key] = tied.Value
+		}
+		if key.Key == "score" {
+			clause[key.Key] = bson.M{"$gt": key.Value}
+		} else {
+			clause[key.Key] = bson.M{"$lt": key.Value}
+		}
+		ahead = append(ahead, clause)
+	}
+	return bson.M{"$or": ahead}
+}
+
+// live hides soft deleted entries from filter and returns it
+func live(filter bson.M) bson.M {
+	filter["deleted_at"] = bson.M{"$exists": false}
+	return filter
+}
+
+// waitingQuery narrows the audience to entries still waiting for an invite.
+// Entries created before statuses existed count as waiting.
+func waitingQuery(audience models.AudienceFilter) bson.M {
+	query := audience.Query()
+	query["status"] = bson.M{"$in": bson.A{nil, models.WAITING_ENTRY_STATUS}}
+	return query
+}
+
+// syncPriority flags the entries of list matched by filter that carry one of
+// its priority tags, and clears the flag on the others
+func syncPriority(ctx context.Context, collection *tenant.Collection, list *models.Waitlist, filter bson.M) error {
+	tags := models.NormalizeTags(list.Settings.PriorityTags)
+
+	tagged := bson.M{"waitlist": list.Slug, "tags": bson.M{"$in": tags}, "priority": bson.M{"$ne": true}}
+	untagged := bson.M{"waitlist": list.Slug, "tags": bson.M{"$nin": tags}, "priority": true}
+	for key, value := range filter {
+		tagged[key] = value
+		untagged[key] = value
+	}
+
+	if _, err := collection.UpdateMany(ctx, tagged, bson.M{"$set": bson.M{"priority": true}}); err != nil {
+		return err
+	}
+	_, err := collection.UpdateMany(ctx, untagged, bson.M{"$unset": bson.M{"priority": ""}})
+	return err
+}
+
+// tagQuery narrows filter to entries carrying all of tags and returns it
+func tagQuery(filter bson.M, tags []string) bson.M {
+	if tags := models.NormalizeTags(tags); len(tags) > 0 {
+		filter["tags"] = bson.M{"$all": tags}
+	}
+	return filter
+}
@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+	"waitlist/lib/tenant"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// error codes returned to the product app when a code can't be redeemed
+const (
+	invalidCodeError  = "invalid_code"
+	codeRedeemedError = "code_already_redeemed"
+	codeExpiredError  = "code_expired"
+	codeRevokedError  = "code_revoked"
+)
+
+type redeemRequest struct {
+	Code string `json:"code"`
+}
+
+// RedeemInvite verifies and consumes an invite code for the product app. The
+// invite is consumed atomically so a code can only ever be redeemed once, and
+// given back when its entry can't accept it.
+// Codes are unique across organizations, the invite decides the tenant of the entry.
+func (w *Waitlist) RedeemInvite() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		invites := w.db.Collection("invites")
+		ctx := context.Background()
+
+		request := redeemRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+		if request.Code == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": invalidCodeError, "message": "code is required"})
+			return
+		}
+
+		now := time.Now().Unix()
+		codeHash := hashInviteCode(request.Code)
+		filter := bson.M{"code_hash": codeHash, "status": models.SENT_INVITE_STATUS, "expires_at": bson.M{"$gt": now}}
+		update := bson.M{"$set": bson.M{"status": models.ACCEPTED_INVITE_STATUS, "accepted_at": now}}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		invite := models.Invite{}
+		err := invites.FindOneAndUpdate(ctx, filter, update, opts).Decode(&invite)
+		if err == mongo.ErrNoDocuments {
+			w.rejectInviteCode(c, codeHash, now)
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		// only an invited entry can accept, the code is given back when it can't
+		entry := models.WaitlistEntry{}
+		db := tenant.Scope(w.db, invite.TenantID)
+		entryFilter := live(bson.M{"_id": invite.EntryID, "status": models.INVITED_ENTRY_STATUS})
+		entryUpdate := bson.M{"$set": bson.M{"status": models.ACCEPTED_ENTRY_STATUS, "accepted_at": now}}
+		err = db.Collection("waitlist").FindOneAndUpdate(ctx, entryFilter, entryUpdate, opts).Decode(&entry)
+		if err != nil {
+			w.releaseInvite(ctx, &invite)
+			if err == mongo.ErrNoDocuments {
+				w.rejectInviteEntry(c, db, &invite)
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"entry": entry, "invite": invite})
+	}
+}
+
+// rejectInviteCode explains why a code could not be consumed
+func (w *Waitlist) rejectInviteCode(c *gin.Context, codeHash string, now int64) {
+	invite := models.Invite{}
+	err := w.db.Collection("invites").FindOne(context.Background(), bson.M{"code_hash": codeHash}).Decode(&invite)
+	if err == mongo.ErrNoDocuments {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": invalidCodeError, "message": "invite code not found"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+		return
+	}
+
+	switch {
+	case invite.Status == models.ACCEPTED_INVITE_STATUS:
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": codeRedeemedError, "message": "invite code has already been redeemed"})
+	case invite.Status == models.REVOKED_INVITE_STATUS:
+		c.AbortWithStatusJSON(http.StatusGone, gin.H{"error": codeRevokedError, "message": "invite code has been replaced by a newer one"})
+	case invite.Status == models.EXPIRED_INVITE_STATUS || invite.ExpiresAt <= now:
+		c.AbortWithStatusJSON(http.StatusGone, gin.H{"error": codeExpiredError, "message": "invite code has expired"})
+	default:
+		// the invite changed between the update and this lookup
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": codeRedeemedError, "message": "invite code has already been redeemed"})
+	}
+}
+
+// releaseInvite gives back an invite consumed for an entry that couldn't accept it
+func (w *Waitlist) releaseInvite(ctx context.Context, invite *models.Invite) {
+	filter := bson.M{"_id": invite.ID, "status": models.ACCEPTED_INVITE_STATUS}
+	update := bson.M{"$set": bson.M{"status": models.SENT_INVITE_STATUS}, "$unset": bson.M{"accepted_at": ""}}
+	if _, err := w.db.Collection("invites").UpdateOne(ctx, filter, update); err != nil {
+		log.Println("unable to release invite", invite.ID.Hex(), err)
+	}
+}
+
+// rejectInviteEntry explains why the entry of a valid code could not accept it
+func (w *Waitlist) rejectInviteEntry(c *gin.Context, db *tenant.Database, invite *models.Invite) {
+	entry := models.WaitlistEntry{}
+	err := db.Collection("waitlist").FindOne(context.Background(), bson.M{"_id": invite.EntryID}).Decode(&entry)
+	if err != nil && err != mongo.ErrNoDocuments {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+		return
+	}
+
+	switch {
+	case err == mongo.ErrNoDocuments || entry.DeletedAt != 0:
+		c.AbortWithStatusJSON(http.StatusGone, gin.H{"error": invalidCodeError, "message": "waitlist entry no longer exists"})
+	case entry.Status == models.ACCEPTED_ENTRY_STATUS:
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": codeRedeemedError, "message": "invite has already been accepted"})
+	case entry.Status == models.EXPIRED_ENTRY_STATUS:
+		c.AbortWithStatusJSON(http.StatusGone, gin.H{"error": codeExpiredError, "message": "invite code has expired"})
+	default:
+		// the entry was put back in the queue meanwhile
+		c.AbortWithStatusJSON(http.StatusGone, gin.H{"error": codeRevokedError, "message": "invite code is no longer valid"})
+	}
+}
@@ -0,0 +1,329 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"waitlist/lib/tenant"
+	"waitlist/middleware"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// email templates
+	RewardAlias = "referral-reward"
+
+	defaultLeaderboardSize = 10
+	maxLeaderboardSize     = 50
+)
+
+type referralRequest struct {
+	Tiers []models.RewardTier `json:"tiers"`
+}
+
+// validate checks the tiers and sorts them by referrals, returning a message for the caller
+func (r *referralRequest) validate() string {
+	names := map[string]bool{}
+	for i := range r.Tiers {
+		tier := &r.Tiers[i]
+		tier.Name = strings.TrimSpace(tier.Name)
+		if tier.Name == "" {
+			return "every tier needs a name"
+		}
+		if names[tier.Name] {
+			return "tier names must be unique"
+		}
+		names[tier.Name] = true
+		if tier.Referrals <= 0 {
+			return "tier referrals must be positive"
+		}
+	}
+	sort.SliceStable(r.Tiers, func(i, j int) bool { return r.Tiers[i].Referrals < r.Tiers[j].Referrals })
+	return ""
+}
+
+// leader is a leaderboard row. Addresses are masked, the board is public.
+type leader struct {
+	Rank      int    `json:"rank"`
+	Name      string `json:"name"`
+	Referrals int    `json:"referrals"`
+}
+
+// publicTier is a reward tier without its template
+type publicTier struct {
+	Name      string `json:"name"`
+	Referrals int    `json:"referrals"`
+}
+
+// Get the top referrers of a waitlist, with their addresses masked, and the
+// reward tiers they compete for
+func (w *Waitlist) GetLeaderboard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		list, ok := w.loadPublicWaitlist(c)
+		if !ok {
+			return
+		}
+		db := w.scoped(c)
+
+		limit, err := queryInt(c, "limit", defaultLeaderboardSize)
+		if err != nil || limit <= 0 || limit > maxLeaderboardSize {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 50"})
+			return
+		}
+
+		filter := live(bson.M{"waitlist": list.Slug, "referral_count": bson.M{"$gt": 0}})
+		opts := options.Find().
+			SetSort(bson.D{{Key: "referral_count", Value: -1}, {Key: "timestamp", Value: 1}}).
+			SetProjection(bson.M{"email": 1, "referral_count": 1}).
+			SetLimit(limit)
+		cursor, err := db.Collection("waitlist").Find(ctx, filter, opts)
+		if err != nil {
+			log.Println("MongoDb find error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the leaderboard"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		entries := []models.WaitlistEntry{}
+		if err := cursor.All(ctx, &entries); err != nil {
+			log.Println("MongoDb decode error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error decoding document"})
+			return
+		}
+		leaders := make([]leader, len(entries))
+		for i, entry := range entries {
+			leaders[i] = leader{Rank: i + 1, Name: maskEmail(entry.Email), Referrals: entry.ReferralCount}
+		}
+
+		settings, err := w.referralSettings(ctx, db, list.Slug)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+		tiers := make([]publicTier, len(settings.Tiers))
+		for i, tier := range settings.Tiers {
+			tiers[i] = publicTier{Name: tier.Name, Referrals: tier.Referrals}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"waitlist": list.Slug, "leaders": leaders, "tiers": tiers})
+	}
+}
+
+func (w *Waitlist) GetReferralSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		settings, err := w.referralSettings(context.Background(), w.scoped(c), list.Slug)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// Set the reward tiers of a waitlist. Entries earn a tier on their next
+// referral once they have enough, tiers already earned aren't sent again.
+func (w *Waitlist) UpdateReferralSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		collection := w.scoped(c).Collection("referral_settings")
+		ctx := context.Background()
+
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		request := referralRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+		if msg := request.validate(); msg != "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		if request.Tiers == nil {
+			request.Tiers = []models.RewardTier{}
+		}
+
+		now := time.Now().Unix()
+		update := bson.M{"$set": bson.M{"tiers": request.Tiers, "updated_at": now}}
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+
+		before := models.ReferralSettings{Waitlist: list.Slug, Tiers: []models.RewardTier{}}
+		err := collection.FindOneAndUpdate(ctx, bson.M{"_id": list.Slug}, update, opts).Decode(&before)
+		if err != nil && err != mongo.ErrNoDocuments {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		settings := before
+		settings.Tiers = request.Tiers
+		settings.UpdatedAt = now
+		middleware.AuditState(c, before, settings)
+
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// Get the rewards earned on a waitlist, most recent first, by email, tier or status
+func (w *Waitlist) GetReferralRewards() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		filter := bson.M{"waitlist": list.Slug}
+		for _, key := range []string{"email", "tier", "status"} {
+			if value := c.Query(key); value != "" {
+				filter[key] = value
+			}
+		}
+		limit, skip, err := pagination(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "earned_at", Value: -1}}).SetLimit(limit).SetSkip(skip)
+		cursor, err := w.scoped(c).Collection("referral_rewards").Find(ctx, filter, opts)
+		if err != nil {
+			log.Println("MongoDb find error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching rewards"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		rewards := []models.ReferralReward{}
+		if err := cursor.All(ctx, &rewards); err != nil {
+			log.Println("MongoDb decode error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error decoding document"})
+			return
+		}
+
+		c.JSON(http.StatusOK, rewards)
+	}
+}
+
+// findReferrer returns the id of the live entry of list sharing code, or a
+// zero id when there is none. Entries can't refer themselves.
+func (w *Waitlist) findReferrer(ctx context.Context, db *tenant.Database, list *models.Waitlist, code string, email string) (primitive.ObjectID, error) {
+	if code == "" {
+		return primitive.NilObjectID, nil
+	}
+
+	referrer := models.WaitlistEntry{}
+	opts := options.FindOne().SetProjection(bson.M{"email": 1})
+	err := db.Collection("waitlist").FindOne(ctx, live(bson.M{"waitlist": list.Slug, "referral_code": strings.ToLower(code)}), opts).Decode(&referrer)
+	if err == mongo.ErrNoDocuments || (err == nil && referrer.Email == email) {
+		return primitive.NilObjectID, nil
+	} else if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return referrer.ID, nil
+}
+
+// creditReferral counts a confirmed signup referred by referrer, rescores it and
+// records the tiers it reached
+func (w *Waitlist) creditReferral(ctx context.Context, db *tenant.Database, list *models.Waitlist, referrer primitive.ObjectID) error {
+	entry := models.WaitlistEntry{}
+	update := bson.M{"$inc": bson.M{"referral_count": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := db.Collection("waitlist").FindOneAndUpdate(ctx, bson.M{"_id": referrer}, update, opts).Decode(&entry); err != nil {
+		return err
+	}
+
+	if err := w.rescore(ctx, db, list, bson.M{"_id": entry.ID}); err != nil {
+		log.Println("unable to rescore entry:", err)
+	}
+	return w.awardRewards(ctx, db, list, &entry)
+}
+
+// awardRewards records every tier entry has reached and not earned yet. The
+// emails are sent by RunRewards.
+func (w *Waitlist) awardRewards(ctx context.Context, db *tenant.Database, list *models.Waitlist, entry *models.WaitlistEntry) error {
+	settings, err := w.referralSettings(ctx, db, list.Slug)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, tier := range settings.Tiers {
+		if entry.ReferralCount < tier.Referrals {
+			continue
+		}
+		reward := models.ReferralReward{
+			Waitlist:  list.Slug,
+			EntryID:   entry.ID,
+			Email:     entry.Email,
+			Tier:      tier.Name,
+			Referrals: tier.Referrals,
+			Template:  template(tier.Template, RewardAlias),
+			Status:    models.PENDING_REWARD_STATUS,
+			EarnedAt:  now,
+			UpdatedAt: now,
+		}
+		_, err := db.Collection("referral_rewards").InsertOne(ctx, reward)
+		if mongo.IsDuplicateKeyError(err) {
+			// earned before
+			continue
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// referralSettings returns the referral settings of a waitlist, without tiers when it has none
+func (w *Waitlist) referralSettings(ctx context.Context, db *tenant.Database, slug string) (*models.ReferralSettings, error) {
+	settings := models.ReferralSettings{Waitlist: slug, Tiers: []models.RewardTier{}}
+	err := db.Collection("referral_settings").FindOne(ctx, bson.M{"_id": slug}).Decode(&settings)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// maskEmail hides most of an address, keeping enough for people to recognise
+// themselves: jane.doe@example.com becomes ja***@ex***.com
+func maskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return maskPart(email)
+	}
+	name, tld := domain, ""
+	if i := strings.LastIndex(domain, "."); i > 0 {
+		name, tld = domain[:i], domain[i:]
+	}
+	return maskPart(local) + "@" + maskPart(name) + tld
+}
+
+// maskPart keeps the first character of part, two of longer ones
+func maskPart(part string) string {
+	runes := []rune(part)
+	keep := 1
+	if len(runes) > 4 {
+		keep = 2
+	}
+	if len(runes) < keep {
+		keep = len(runes)
+	}
+	return string(runes[:keep]) + "***"
+}
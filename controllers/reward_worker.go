@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+	"waitlist/lib/emailclient"
+	"waitlist/lib/tenant"
+	"waitlist/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	rewardInterval = 10 * time.Second
+	// rewardUpdateAttempts is how many times the outcome of a send is written
+	rewardUpdateAttempts = 5
+)
+
+// RunRewards emails the referral rewards entries earned until ctx is done
+func (w *Waitlist) RunRewards(ctx context.Context) {
+	ticker := time.NewTicker(rewardInterval)
+	defer ticker.Stop()
+
+	for {
+		w.sendRewards(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendRewards claims the pending rewards of every organization one at a time,
+// so replicas never announce the same reward twice. Provider rejections are
+// failures, returned by the notifier as notifier.ErrRejected.
+func (w *Waitlist) sendRewards(ctx context.Context) {
+	rewards := w.db.Collection("referral_rewards")
+
+	stale := bson.M{
+		"status":     models.SENDING_REWARD_STATUS,
+		"updated_at": bson.M{"$lt": time.Now().Add(-sendClaimTimeout).Unix()},
+	}
+	requeue := bson.M{"$set": bson.M{"status": models.PENDING_REWARD_STATUS}}
+	if _, err := rewards.UpdateMany(ctx, stale, requeue); err != nil {
+		log.Println("unable to requeue rewards:", err)
+	}
+
+	for {
+		reward := models.ReferralReward{}
+		claim := bson.M{"$set": bson.M{"status": models.SENDING_REWARD_STATUS, "updated_at": time.Now().Unix()}}
+		opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "earned_at", Value: 1}}).SetReturnDocument(options.After)
+		err := rewards.FindOneAndUpdate(ctx, bson.M{"status": models.PENDING_REWARD_STATUS}, claim, opts).Decode(&reward)
+		if err == mongo.ErrNoDocuments {
+			return
+		} else if err != nil {
+			log.Println("unable to claim reward:", err)
+			return
+		}
+
+		set := w.sendReward(ctx, &reward)
+		if err := recordReward(ctx, rewards, &reward, set); err != nil {
+			log.Println("unable to update reward:", err)
+			return
+		}
+	}
+}
+
+// recordReward stores the outcome of sending reward, retrying a few times
+// since a reward left sending is requeued after sendClaimTimeout and emailed
+// again. Only when every attempt fails can a reward go out twice.
+func recordReward(ctx context.Context, rewards *mongo.Collection, reward *models.ReferralReward, set bson.M) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if _, err = rewards.UpdateOne(ctx, bson.M{"_id": reward.ID}, bson.M{"$set": set}); err == nil || attempt == rewardUpdateAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+}
+
+// sendReward emails reward to the entry that earned it and returns the fields recording the outcome
+func (w *Waitlist) sendReward(ctx context.Context, reward *models.ReferralReward) bson.M {
+	now := time.Now().Unix()
+	set := bson.M{"status": models.SENT_REWARD_STATUS, "updated_at": now}
+	db := tenant.Scope(w.db, reward.TenantID)
+
+	list, err := w.findWaitlist(ctx, db, reward.Waitlist)
+	if err != nil {
+		set["status"] = models.FAILED_REWARD_STATUS
+		set["error"] = err.Error()
+		return set
+	}
+	entry := models.WaitlistEntry{}
+	err = db.Collection("waitlist").FindOne(ctx, live(bson.M{"_id": reward.EntryID})).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		set["status"] = models.SKIPPED_REWARD_STATUS
+		set["error"] = "entry was deleted"
+		return set
+	} else if err != nil {
+		set["status"] = models.FAILED_REWARD_STATUS
+		set["error"] = err.Error()
+		return set
+	}
+
+	data := map[string]string{"Tier": reward.Tier, "Referrals": strconv.Itoa(entry.ReferralCount)}
+	err = w.sendMsg(list, &entry, "referral-reward", reward.Template, data)
+	if err == emailclient.ErrSuppressed {
+		set["status"] = models.SKIPPED_REWARD_STATUS
+		set["error"] = err.Error()
+	} else if err != nil {
+		set["status"] = models.FAILED_REWARD_STATUS
+		set["error"] = err.Error()
+	} else {
+		set["sent_at"] = now
+	}
+	return set
+}
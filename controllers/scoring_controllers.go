@@ -0,0 +1,325 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+	"waitlist/lib/tenant"
+	"waitlist/middleware"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type scoringRequest struct {
+	Enabled   bool                `json:"enabled"`
+	SignupDay float64             `json:"signup_day"`
+	Referral  float64             `json:"referral"`
+	Confirmed float64             `json:"confirmed"`
+	Tags      []models.TagScore   `json:"tags"`
+	Fields    []models.FieldScore `json:"fields"`
+}
+
+// validate checks the scored tags and fields against list and returns a message for the caller
+func (r *scoringRequest) validate(list *models.Waitlist) string {
+	for i := range r.Tags {
+		tags := models.NormalizeTags([]string{r.Tags[i].Tag})
+		if len(tags) == 0 {
+			return "scored tags can't be empty"
+		}
+		r.Tags[i].Tag = tags[0]
+	}
+	for _, score := range r.Fields {
+		field, ok := findField(list.Fields, score.Field)
+		if !ok {
+			return score.Field + " is not a field of this waitlist"
+		}
+		if score.Value == "" && field.Type != models.NUMBER_FIELD_TYPE && field.Type != models.BOOLEAN_FIELD_TYPE {
+			return "a value to match is required to score " + score.Field
+		}
+	}
+	return ""
+}
+
+type boostRequest struct {
+	Boost float64 `json:"boost"`
+}
+
+func (w *Waitlist) GetScoringSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		settings, err := w.scoringSettings(context.Background(), w.scoped(c), list.Slug)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// Set how the entries of a waitlist are scored. Every entry is rescored in the
+// background, the new order applies to all of them once scored_version
+// reaches version.
+func (w *Waitlist) UpdateScoringSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		collection := w.scoped(c).Collection("scoring_settings")
+		ctx := context.Background()
+
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		request := scoringRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+		if msg := request.validate(list); msg != "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		now := time.Now().Unix()
+		update := bson.M{
+			"$set": bson.M{
+				"enabled":    request.Enabled,
+				"signup_day": request.SignupDay,
+				"referral":   request.Referral,
+				"confirmed":  request.Confirmed,
+				"tags":       request.Tags,
+				"fields":     request.Fields,
+				"updated_at": now,
+			},
+			"$inc":         bson.M{"version": 1},
+			"$setOnInsert": bson.M{"scored_version": 0},
+		}
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+
+		before := models.ScoringSettings{Waitlist: list.Slug}
+		err := collection.FindOneAndUpdate(ctx, bson.M{"_id": list.Slug}, update, opts).Decode(&before)
+		if err != nil && err != mongo.ErrNoDocuments {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		settings := before
+		settings.Enabled = request.Enabled
+		settings.SignupDay = request.SignupDay
+		settings.Referral = request.Referral
+		settings.Confirmed = request.Confirmed
+		settings.Tags = request.Tags
+		settings.Fields = request.Fields
+		settings.Version++
+		settings.UpdatedAt = now
+		middleware.AuditState(c, before, settings)
+
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// Rescore every entry of a waitlist in the background, for example after the
+// values of its custom fields were corrected
+func (w *Waitlist) RecalculateScores() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		update := bson.M{"$inc": bson.M{"version": 1}}
+		result, err := w.scoped(c).Collection("scoring_settings").UpdateOne(context.Background(), bson.M{"_id": list.Slug}, update)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Scoring is not configured"})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"message": "Scores are being recalculated"})
+	}
+}
+
+// Set the manual boost of an entry, added to its score
+func (w *Waitlist) SetEntryBoost() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		request := boostRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+
+		update := bson.M{"$set": bson.M{"boost": request.Boost}}
+		if request.Boost == 0 {
+			update = bson.M{"$unset": bson.M{"boost": ""}}
+		}
+		before, ok := w.updateEntry(c, list, bson.M{}, update, "Email not found")
+		if !ok {
+			return
+		}
+
+		entry := before
+		entry.Boost = request.Boost
+		if err := w.rescore(context.Background(), w.scoped(c), list, bson.M{"_id": entry.ID}); err != nil {
+			log.Println("unable to rescore entry:", err)
+		}
+		auditEntry(c, &before, &entry)
+
+		c.JSON(http.StatusOK, gin.H{"email": entry.Email, "boost": entry.Boost})
+	}
+}
+
+// Get the place of a waiting entry in the queue, 1 being the next to be invited
+func (w *Waitlist) GetEntryPosition() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		db := w.scoped(c)
+		collection := db.Collection("waitlist")
+
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		entry := models.WaitlistEntry{}
+		err := collection.FindOne(ctx, live(bson.M{"waitlist": list.Slug, "email": c.Param("email")})).Decode(&entry)
+		if err == mongo.ErrNoDocuments {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Email not found"})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+		if entry.Status != "" && entry.Status != models.WAITING_ENTRY_STATUS {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "Entry is no longer waiting", "status": entry.Status})
+			return
+		}
+
+		settings, err := w.scoringSettings(ctx, db, list.Slug)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		query := waitingQuery(models.AudienceFilter{Waitlist: list.Slug})
+		query["$and"] = bson.A{aheadQuery(&entry, settings.Enabled)}
+		ahead, err := collection.CountDocuments(ctx, query)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		response := gin.H{"email": entry.Email, "position": ahead + 1, "priority": entry.Priority}
+		if settings.Enabled {
+			response["score"] = entry.Score
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// scoringSettings returns the scoring settings of a waitlist, disabled when it has none
+func (w *Waitlist) scoringSettings(ctx context.Context, db *tenant.Database, slug string) (*models.ScoringSettings, error) {
+	settings := models.ScoringSettings{Waitlist: slug}
+	err := db.Collection("scoring_settings").FindOne(ctx, bson.M{"_id": slug}).Decode(&settings)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// rescore recomputes the score of the live entries of list matched by filter,
+// writing only the scores that changed. It does nothing while scoring is off.
+func (w *Waitlist) rescore(ctx context.Context, db *tenant.Database, list *models.Waitlist, filter bson.M) error {
+	return w.rescoreBatches(ctx, db, list, filter, nil)
+}
+
+// rescoreBatches is rescore calling batchDone, when set, after every
+// bulkBatchSize entries read. An error from batchDone stops the rescoring.
+func (w *Waitlist) rescoreBatches(ctx context.Context, db *tenant.Database, list *models.Waitlist, filter bson.M, batchDone func() error) error {
+	settings, err := w.scoringSettings(ctx, db, list.Slug)
+	if err != nil || !settings.Enabled {
+		return err
+	}
+	collection := db.Collection("waitlist")
+
+	query := live(bson.M{"waitlist": list.Slug})
+	for key, value := range filter {
+		query[key] = value
+	}
+
+	// entries from before scoring have no score, which would sort them after every scored one
+	unscored := bson.M{"score": bson.M{"$exists": false}}
+	for key, value := range query {
+		unscored[key] = value
+	}
+	if _, err := collection.UpdateMany(ctx, unscored, bson.M{"$set": bson.M{"score": 0}}); err != nil {
+		return err
+	}
+
+	cursor, err := collection.Find(ctx, query, options.Find().SetBatchSize(bulkBatchSize))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	writes := []mongo.WriteModel{}
+	flush := func() error {
+		if len(writes) == 0 {
+			return nil
+		}
+		_, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+		writes = writes[:0]
+		var bulkErr mongo.BulkWriteException
+		if errors.As(err, &bulkErr) {
+			// entries that couldn't be written keep their old score until the next event
+			log.Println("unable to rescore some entries:", err)
+			return nil
+		}
+		return err
+	}
+
+	read := 0
+	for cursor.Next(ctx) {
+		entry := models.WaitlistEntry{}
+		if err := cursor.Decode(&entry); err != nil {
+			return err
+		}
+		if read++; batchDone != nil && read%bulkBatchSize == 0 {
+			if err := batchDone(); err != nil {
+				return err
+			}
+		}
+		score := settings.Score(list, &entry)
+		if score == entry.Score {
+			continue
+		}
+		update := bson.M{"$set": bson.M{"score": score}}
+		writes = append(writes, mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": entry.ID}).SetUpdate(update))
+		if len(writes) == bulkBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
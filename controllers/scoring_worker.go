@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+	"waitlist/lib/tenant"
+	"waitlist/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	scoringInterval = 30 * time.Second
+	// scoringLease is how long a replica owns the rescoring of a waitlist
+	// without renewing it, which it does between batches
+	scoringLease = 2 * time.Minute
+)
+
+var errLeaseLost = errors.New("rescoring lease was taken over")
+
+// RunScoring rescores every entry of the waitlists whose scoring changed, until ctx is done
+func (w *Waitlist) RunScoring(ctx context.Context) {
+	ticker := time.NewTicker(scoringInterval)
+	defer ticker.Stop()
+
+	for {
+		w.rescoreChanged(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// rescoreChanged rescores the waitlists of every organization whose settings
+// are ahead of their entries. Each waitlist is leased before it is scored so
+// replicas don't duplicate the work, and a version is only marked scored once
+// every entry got its score. Leases are renewed between batches, and those of
+// replicas that stopped expire after scoringLease.
+func (w *Waitlist) rescoreChanged(ctx context.Context) {
+	collection := w.db.Collection("scoring_settings")
+
+	filter := bson.M{"$expr": bson.M{"$gt": bson.A{"$version", "$scored_version"}}}
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		log.Println("MongoDb find error:", err)
+		return
+	}
+	pending := []models.ScoringSettings{}
+	if err := cursor.All(ctx, &pending); err != nil {
+		log.Println("MongoDb decode error", err)
+		return
+	}
+
+	for _, settings := range pending {
+		now := time.Now().Unix()
+		claim := bson.M{
+			"_id":       settings.Waitlist,
+			"tenant_id": settings.TenantID,
+			"$expr":     bson.M{"$gt": bson.A{"$version", "$scored_version"}},
+			"$or": bson.A{
+				bson.M{"scoring_started_at": bson.M{"$exists": false}},
+				bson.M{"scoring_started_at": bson.M{"$lt": now - int64(scoringLease.Seconds())}},
+			},
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		claimed := models.ScoringSettings{}
+		err := collection.FindOneAndUpdate(ctx, claim, bson.M{"$set": bson.M{"scoring_started_at": now}}, opts).Decode(&claimed)
+		if err == mongo.ErrNoDocuments {
+			// another replica is on it, or it was scored meanwhile
+			continue
+		} else if err != nil {
+			log.Println("unable to claim rescoring:", err)
+			continue
+		}
+
+		lease := bson.M{"_id": claimed.Waitlist, "tenant_id": claimed.TenantID, "scoring_started_at": now}
+		renew := func() error {
+			renewed := time.Now().Unix()
+			result, err := collection.UpdateOne(ctx, lease, bson.M{"$set": bson.M{"scoring_started_at": renewed}})
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return errLeaseLost
+			}
+			lease["scoring_started_at"] = renewed
+			return nil
+		}
+		release := bson.M{"$unset": bson.M{"scoring_started_at": ""}}
+		if err := w.rescoreWaitlist(ctx, &claimed, renew); err != nil {
+			log.Println("unable to rescore", claimed.Waitlist, err)
+		} else {
+			// settings changed while scoring keep a newer version, and are scored again
+			release["$max"] = bson.M{"scored_version": claimed.Version}
+		}
+		if _, err := collection.UpdateOne(ctx, lease, release); err != nil {
+			log.Println("unable to release rescoring:", err)
+		}
+	}
+}
+
+// rescoreWaitlist rescores every entry of the waitlist of settings, calling
+// renew between batches to keep the lease
+func (w *Waitlist) rescoreWaitlist(ctx context.Context, settings *models.ScoringSettings, renew func() error) error {
+	db := tenant.Scope(w.db, settings.TenantID)
+	list, err := w.findWaitlist(ctx, db, settings.Waitlist)
+	if err == errWaitlistNotFound {
+		// a deleted waitlist has nothing left to score
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return w.rescoreBatches(ctx, db, list, bson.M{}, renew)
+}
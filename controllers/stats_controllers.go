@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+	"waitlist/middleware"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// statsTTL is how long a stats response is served from memory
+const statsTTL = 30 * time.Second
+
+// statsIntervals are the supported series buckets
+var statsIntervals = map[string]bool{"hour": true, "day": true, "week": true}
+
+type statsTotals struct {
+	Signups          int64   `json:"signups" bson:"signups"`
+	Confirmed        int64   `json:"confirmed" bson:"confirmed"`
+	Pending          int64   `json:"pending" bson:"-"`
+	Unsubscribed     int64   `json:"unsubscribed" bson:"unsubscribed"`
+	Invited          int64   `json:"invited" bson:"invited"`
+	Accepted         int64   `json:"accepted" bson:"accepted"`
+	InviteConversion float64 `json:"invite_conversion" bson:"-"`
+}
+
+type statsPoint struct {
+	Period    string `json:"period"`
+	Signups   int64  `json:"signups"`
+	Confirmed int64  `json:"confirmed"`
+}
+
+type statsResponse struct {
+	Waitlist    string       `json:"waitlist"`
+	Interval    string       `json:"interval"`
+	Timezone    string       `json:"timezone"`
+	From        int64        `json:"from,omitempty"`
+	To          int64        `json:"to,omitempty"`
+	Totals      statsTotals  `json:"totals"`
+	Series      []statsPoint `json:"series"`
+	GeneratedAt int64        `json:"generated_at"`
+}
+
+// statsCache keeps recent stats responses so dashboards polling the endpoint
+// don't run the aggregation on every request
+type statsCache struct {
+	mu      sync.Mutex
+	entries map[string]statsResponse
+}
+
+func newStatsCache() *statsCache {
+	return &statsCache{entries: map[string]statsResponse{}}
+}
+
+func (s *statsCache) get(key string) (statsResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats, ok := s.entries[key]
+	if !ok || time.Since(time.Unix(stats.GeneratedAt, 0)) > statsTTL {
+		return statsResponse{}, false
+	}
+	return stats, true
+}
+
+func (s *statsCache) put(key string, stats statsResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// drop expired entries so the map doesn't grow with every distinct query
+	for k, v := range s.entries {
+		if time.Since(time.Unix(v.GeneratedAt, 0)) > statsTTL {
+			delete(s.entries, k)
+		}
+	}
+	s.entries[key] = stats
+}
+
+// Get signup totals and a time series of a waitlist. interval is hour, day or
+// week (default day), tz an IANA time zone for the buckets (default UTC), from
+// and to bound the signup time as unix timestamps.
+func (w *Waitlist) GetStats() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		interval := c.DefaultQuery("interval", "day")
+		if !statsIntervals[interval] {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "interval must be hour, day or week"})
+			return
+		}
+		location, err := time.LoadLocation(c.DefaultQuery("tz", "UTC"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unknown time zone"})
+			return
+		}
+		from, err := queryInt(c, "from", 0)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "from must be a unix timestamp"})
+			return
+		}
+		to, err := queryInt(c, "to", 0)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "to must be a unix timestamp"})
+			return
+		}
+
+		key := c.GetString(middleware.TenantKey) + "|" + list.Slug + "|" + interval + "|" + location.String() + "|" + c.Query("from") + "|" + c.Query("to")
+		if stats, ok := w.stats.get(key); ok {
+			c.JSON(http.StatusOK, stats)
+			return
+		}
+
+		match := live(bson.M{"waitlist": list.Slug})
+		ts := bson.M{}
+		if from > 0 {
+			ts["$gte"] = from
+		}
+		if to > 0 {
+			ts["$lt"] = to
+		}
+		if len(ts) > 0 {
+			match["timestamp"] = ts
+		}
+
+		confirmed := bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$confirmed_at", 0}}, 1, 0}}
+		pipeline := bson.A{
+			bson.M{"$match": match},
+			bson.M{"$facet": bson.M{
+				"totals": bson.A{bson.M{"$group": bson.M{
+					"_id":          nil,
+					"signups":      bson.M{"$sum": 1},
+					"confirmed":    bson.M{"$sum": confirmed},
+					"unsubscribed": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$ifNull": bson.A{"$opt_out", false}}, 1, 0}}},
+					"invited": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$in": bson.A{"$status", bson.A{
+						models.INVITED_ENTRY_STATUS, models.ACCEPTED_ENTRY_STATUS, models.EXPIRED_ENTRY_STATUS,
+					}}}, 1, 0}}},
+					"accepted": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$status", models.ACCEPTED_ENTRY_STATUS}}, 1, 0}}},
+				}}},
+				"series": bson.A{
+					bson.M{"$group": bson.M{
+						"_id": bson.M{"$dateTrunc": bson.M{
+							"date":        bson.M{"$toDate": bson.M{"$multiply": bson.A{"$timestamp", 1000}}},
+							"unit":        interval,
+							"timezone":    location.String(),
+							"startOfWeek": "monday",
+						}},
+						"signups":   bson.M{"$sum": 1},
+						"confirmed": bson.M{"$sum": confirmed},
+					}},
+					bson.M{"$sort": bson.M{"_id": 1}},
+				},
+			}},
+		}
+		cursor, err := w.scoped(c).Collection("waitlist").Aggregate(ctx, pipeline)
+		if err != nil {
+			log.Println("MongoDb aggregate error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while aggregating signups"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		results := []struct {
+			Totals []statsTotals `bson:"totals"`
+			Series []struct {
+				Period    time.Time `bson:"_id"`
+				Signups   int64     `bson:"signups"`
+				Confirmed int64     `bson:"confirmed"`
+			} `bson:"series"`
+		}{}
+		if err := cursor.All(ctx, &results); err != nil || len(results) != 1 {
+			log.Println("MongoDb decode error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error decoding document"})
+			return
+		}
+
+		stats := statsResponse{
+			Waitlist:    list.Slug,
+			Interval:    interval,
+			Timezone:    location.String(),
+			From:        from,
+			To:          to,
+			Series:      make([]statsPoint, len(results[0].Series)),
+			GeneratedAt: time.Now().Unix(),
+		}
+		if len(results[0].Totals) > 0 {
+			stats.Totals = results[0].Totals[0]
+		}
+		stats.Totals.Pending = stats.Totals.Signups - stats.Totals.Confirmed
+		stats.Totals.InviteConversion = rate(stats.Totals.Accepted, stats.Totals.Invited)
+		for i, point := range results[0].Series {
+			stats.Series[i] = statsPoint{
+				Period:    point.Period.In(location).Format(time.RFC3339),
+				Signups:   point.Signups,
+				Confirmed: point.Confirmed,
+			}
+		}
+
+		w.stats.put(key, stats)
+		c.JSON(http.StatusOK, stats)
+	}
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"waitlist/lib/suppression"
+	"waitlist/middleware"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type suppressionRequest struct {
+	Value  string                   `json:"value"`
+	Reason models.SuppressionReason `json:"reason"`
+}
+
+func (w *Waitlist) GetSuppressions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		suppressions, err := w.suppressions.List(context.Background(), c.GetString(middleware.TenantKey))
+		if err != nil {
+			log.Println("MongoDb find error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching suppressions"})
+			return
+		}
+
+		c.JSON(http.StatusOK, suppressions)
+	}
+}
+
+func (w *Waitlist) AddSuppression() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		request := suppressionRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+
+		if suppression.Normalize(request.Value) == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "value is required"})
+			return
+		}
+		if request.Reason == "" {
+			request.Reason = models.MANUAL_SUPPRESSION_REASON
+		}
+		if !request.Reason.Valid() {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unknown suppression reason"})
+			return
+		}
+
+		entry, err := w.suppressions.Add(context.Background(), c.GetString(middleware.TenantKey), request.Value, request.Reason)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to write to database", "message": err.Error()})
+			return
+		}
+		middleware.AuditTarget(c, middleware.AuditHash("value", entry.Value))
+		middleware.AuditState(c, nil, auditedSuppression{ID: entry.ID, Kind: entry.Kind, Reason: entry.Reason, Timestamp: entry.Timestamp})
+
+		c.JSON(http.StatusCreated, entry)
+	}
+}
+
+// Remove an address or domain from the suppression list using URL parameters
+func (w *Waitlist) DeleteSuppression() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value := c.Param("value")
+		if value == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "value parameter is missing"})
+			return
+		}
+
+		err := w.suppressions.Remove(context.Background(), c.GetString(middleware.TenantKey), value)
+		if err == suppression.ErrNotFound {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Suppression not found"})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Suppression removed"})
+	}
+}
@@ -0,0 +1,230 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"waitlist/middleware"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxTagLength  = 64
+	maxNoteLength = 2000
+)
+
+type tagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+func (r *tagsRequest) validate() string {
+	r.Tags = models.NormalizeTags(r.Tags)
+	if len(r.Tags) == 0 {
+		return "at least one tag is required"
+	}
+	for _, tag := range r.Tags {
+		if len(tag) > maxTagLength {
+			return "tags can be at most 64 characters"
+		}
+	}
+	return ""
+}
+
+type noteRequest struct {
+	Text string `json:"text"`
+}
+
+func (r *noteRequest) validate() string {
+	r.Text = strings.TrimSpace(r.Text)
+	if r.Text == "" {
+		return "text is required"
+	}
+	if len(r.Text) > maxNoteLength {
+		return "notes can be at most 2000 characters"
+	}
+	return ""
+}
+
+// Tag an entry. Tags are lowercased, and tagging an entry with a priority tag
+// of its waitlist moves it to the front of the queue.
+func (w *Waitlist) AddEntryTags() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		request := tagsRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+		if msg := request.validate(); msg != "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		update := bson.M{"$addToSet": bson.M{"tags": bson.M{"$each": request.Tags}}}
+		before, ok := w.updateEntry(c, list, bson.M{}, update, "Email not found")
+		if !ok {
+			return
+		}
+
+		entry := before
+		entry.Tags = append([]string{}, before.Tags...)
+		for _, tag := range request.Tags {
+			if !contains(entry.Tags, tag) {
+				entry.Tags = append(entry.Tags, tag)
+			}
+		}
+		if !w.reprioritize(c, list, &entry) {
+			return
+		}
+		auditEntry(c, &before, &entry)
+
+		c.JSON(http.StatusOK, entry)
+	}
+}
+
+// Remove a tag from an entry
+func (w *Waitlist) RemoveEntryTag() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		tag := strings.ToLower(strings.TrimSpace(c.Param("tag")))
+		before, ok := w.updateEntry(c, list, bson.M{}, bson.M{"$pull": bson.M{"tags": tag}}, "Email not found")
+		if !ok {
+			return
+		}
+
+		entry := before
+		entry.Tags = []string{}
+		for _, t := range before.Tags {
+			if t != tag {
+				entry.Tags = append(entry.Tags, t)
+			}
+		}
+		if !w.reprioritize(c, list, &entry) {
+			return
+		}
+		auditEntry(c, &before, &entry)
+
+		c.JSON(http.StatusOK, entry)
+	}
+}
+
+// Add an internal note to an entry, signed with the admin's email
+func (w *Waitlist) AddEntryNote() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		request := noteRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+		if msg := request.validate(); msg != "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		note := models.EntryNote{
+			ID:        primitive.NewObjectID(),
+			Text:      request.Text,
+			Author:    c.GetString(middleware.EmailKey),
+			CreatedAt: time.Now().Unix(),
+		}
+		before, ok := w.updateEntry(c, list, bson.M{}, bson.M{"$push": bson.M{"notes": note}}, "Email not found")
+		if !ok {
+			return
+		}
+		entry := before
+		entry.Notes = append(append([]models.EntryNote{}, before.Notes...), note)
+		auditEntry(c, &before, &entry)
+
+		c.JSON(http.StatusCreated, note)
+	}
+}
+
+// Delete a note from an entry
+func (w *Waitlist) DeleteEntryNote() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid note id"})
+			return
+		}
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		update := bson.M{"$pull": bson.M{"notes": bson.M{"_id": id}}}
+		before, ok := w.updateEntry(c, list, bson.M{"notes._id": id}, update, "Note not found")
+		if !ok {
+			return
+		}
+		entry := before
+		entry.Notes = []models.EntryNote{}
+		for _, note := range before.Notes {
+			if note.ID != id {
+				entry.Notes = append(entry.Notes, note)
+			}
+		}
+		auditEntry(c, &before, &entry)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Note deleted"})
+	}
+}
+
+// updateEntry applies update to the live entry of the :email parameter on
+// list, also matching filter, and returns the entry as it was before. It
+// responds with notFound when nothing matches.
+func (w *Waitlist) updateEntry(c *gin.Context, list *models.Waitlist, filter bson.M, update bson.M, notFound string) (models.WaitlistEntry, bool) {
+	filter["waitlist"] = list.Slug
+	filter["email"] = c.Param("email")
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+
+	before := models.WaitlistEntry{}
+	err := w.scoped(c).Collection("waitlist").FindOneAndUpdate(context.Background(), live(filter), update, opts).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": notFound})
+		return before, false
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+		return before, false
+	}
+	return before, true
+}
+
+// reprioritize moves entry to or from the front of the queue, and rescores
+// it, after its tags changed
+func (w *Waitlist) reprioritize(c *gin.Context, list *models.Waitlist, entry *models.WaitlistEntry) bool {
+	ctx := context.Background()
+	filter := bson.M{"_id": entry.ID}
+	if err := syncPriority(ctx, w.scoped(c).Collection("waitlist"), list, filter); err != nil {
+		log.Println("unable to reorder waitlist:", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+		return false
+	}
+	entry.Priority = containsAny(entry.Tags, models.NormalizeTags(list.Settings.PriorityTags))
+
+	if err := w.rescore(ctx, w.scoped(c), list, filter); err != nil {
+		log.Println("unable to rescore entry:", err)
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+	"waitlist/lib/linksigner"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Check an unsubscribe link without acting on it, so link scanners can't opt people out
+func (w *Waitlist) GetUnsubscribe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		email := c.Query("email")
+		if !w.signer.Verify(linksigner.UnsubscribePurpose, email, c.Query("token")) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "invalid unsubscribe link"})
+			return
+		}
+		list, ok := w.loadPublicWaitlist(c)
+		if !ok {
+			return
+		}
+
+		filter := bson.M{"waitlist": list.Slug, "email": email}
+		entry := models.WaitlistEntry{}
+		err := w.scoped(c).Collection("waitlist").FindOne(context.Background(), filter).Decode(&entry)
+		if err != nil && err != mongo.ErrNoDocuments {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"email": email, "waitlist": list.Slug, "opt_out": entry.OptOut})
+	}
+}
+
+// Unsubscribe handles both RFC 8058 one-click posts and the self-service page.
+// The scope query parameter (or form field) selects between leaving the
+// emails only and leaving the waitlist entirely. Emails are stopped for the
+// address on every waitlist of the organization, leaving only applies to the
+// linked waitlist.
+func (w *Waitlist) Unsubscribe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		email := c.Query("email")
+		if !w.signer.Verify(linksigner.UnsubscribePurpose, email, c.Query("token")) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "invalid unsubscribe link"})
+			return
+		}
+
+		scope := models.OptOutScope(c.DefaultQuery("scope", c.PostForm("scope")))
+		if scope == "" {
+			scope = models.EMAILS_OPT_OUT_SCOPE
+		}
+		if scope != models.EMAILS_OPT_OUT_SCOPE && scope != models.WAITLIST_OPT_OUT_SCOPE {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unknown unsubscribe scope"})
+			return
+		}
+		list, ok := w.loadPublicWaitlist(c)
+		if !ok {
+			return
+		}
+
+		if _, err := w.suppressions.Add(ctx, list.TenantID, email, models.UNSUBSCRIBE_SUPPRESSION_REASON); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		now := time.Now().Unix()
+		collection := w.scoped(c).Collection("waitlist")
+
+		// entries that already left keep their stronger opt out
+		filter := bson.M{"email": email, "opt_out": bson.M{"$ne": models.WAITLIST_OPT_OUT_SCOPE}}
+		update := bson.M{"$set": bson.M{"opt_out": models.EMAILS_OPT_OUT_SCOPE, "opt_out_at": now}}
+		if _, err := collection.UpdateMany(ctx, filter, update); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		if scope == models.WAITLIST_OPT_OUT_SCOPE {
+			filter := bson.M{"waitlist": list.Slug, "email": email}
+			update := bson.M{"$set": bson.M{"opt_out": scope, "opt_out_at": now}}
+			if _, err := collection.UpdateOne(ctx, filter, update); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Unsubscribed", "scope": scope})
+	}
+}
@@ -5,52 +5,139 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"regexp"
 	"time"
+	"waitlist/lib/audit"
 	"waitlist/lib/emailclient"
+	"waitlist/lib/events"
+	"waitlist/lib/linksigner"
+	"waitlist/lib/messagelog"
+	"waitlist/lib/notifier"
+	"waitlist/lib/slack"
+	"waitlist/lib/smsclient"
+	"waitlist/lib/suppression"
+	"waitlist/lib/tenant"
+	"waitlist/lib/webhooks"
 	"waitlist/middleware"
 	"waitlist/models"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type Waitlist struct {
-	db          *mongo.Database
-	emailclient emailclient.EmailClient
-	auth        *middleware.AuthConn
+	db            *mongo.Database
+	emailclient   emailclient.EmailClient
+	notifier      *notifier.Notifier
+	messages      *messagelog.Store
+	suppressions  *suppression.Store
+	signer        *linksigner.Signer
+	organizations *tenant.Directory
+	auth          *middleware.AuthConn
+	stats         *statsCache
+	feed          *events.Feed
+	hooks         *webhooks.Sender
+	slack         *slack.Client
+	trail         *audit.Log
 }
 
 const (
 	// email templates
 	WaitlistAlias = "waitlist-signup"
+
+	// sms bodies, sms providers have no templates
+	WaitlistSMS = "Thanks for joining the waitlist! We'll text you as soon as your invite is ready."
 )
 
-func NewWaitlist(db *mongo.Database, email emailclient.EmailClient, auth *middleware.AuthConn) *Waitlist {
+// secretData are the template data keys whose values grant access, such as
+// invite codes and signed links, and are kept out of the message log. The
+// unsubscribe link is added, and marked secret, by the email client.
+var secretData = []string{"InviteCode", "Link", "ConfirmURL"}
+
+// e164 matches international phone numbers such as +2348012345678
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// signupRequest is the public signup payload, kept apart from the entry so
+// callers can't set admin-managed fields
+type signupRequest struct {
+	Email       string                 `json:"email"`
+	Phone       string                 `json:"phone"`
+	Metadata    map[string]interface{} `json:"metadata"`
+	Acquisition models.Acquisition     `json:"acquisition"`
+	// Ref is the referral code of the entry that shared the waitlist
+	Ref string `json:"ref"`
+}
+
+// NewWaitlist wires the controllers. sms may be nil when no SMS provider is configured.
+func NewWaitlist(db *mongo.Database, email emailclient.EmailClient, sms smsclient.SMSClient, suppressions *suppression.Store, signer *linksigner.Signer, organizations *tenant.Directory, auth *middleware.AuthConn, trail *audit.Log) *Waitlist {
+	messages := messagelog.New(db)
+	notify := notifier.New(messages).Register(models.EMAIL_MESSAGE_TYPE, email)
+	if sms != nil {
+		notify.Register(models.SMS_MESSAGE_TYPE, sms)
+	}
+
 	return &Waitlist{
-		db:          db,
-		emailclient: email,
-		auth:        auth,
+		db:            db,
+		emailclient:   email,
+		notifier:      notify,
+		messages:      messages,
+		suppressions:  suppressions,
+		signer:        signer,
+		organizations: organizations,
+		auth:          auth,
+		stats:         newStatsCache(),
+		feed:          events.New(db.Collection("counters")),
+		hooks:         webhooks.New(nil),
+		slack:         slack.New(nil),
+		trail:         trail,
 	}
 }
 
 func (w *Waitlist) AddToWaitlist() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := context.Background()
-		var waitlistEntry models.WaitlistEntry
-		collection := w.db.Collection("waitlist")
+		var request signupRequest
+
+		list, ok := w.loadPublicWaitlist(c)
+		if !ok {
+			return
+		}
+		collection := w.scoped(c).Collection("waitlist")
+		if list.Settings.Closed {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"Error": "Waitlist is closed"})
+			return
+		}
 
-		if err := c.BindJSON(&waitlistEntry); err != nil {
+		if err := c.BindJSON(&request); err != nil {
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": "Unable to bind waitlist"})
 			return
 		}
+		if request.Phone != "" && !e164.MatchString(request.Phone) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": "Phone number must be in E.164 format, e.g. +2348012345678"})
+			return
+		}
+		metadata, err := signupMetadata(list.Fields, request.Metadata)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+			return
+		}
+		waitlistEntry := models.WaitlistEntry{
+			Waitlist:    list.Slug,
+			Email:       request.Email,
+			Phone:       request.Phone,
+			Metadata:    metadata,
+			Acquisition: acquisition(c, request.Acquisition),
+		}
 
-		filter := bson.M{"email": waitlistEntry.Email}
+		filter := live(bson.M{"waitlist": list.Slug, "email": waitlistEntry.Email})
 		result := collection.FindOne(ctx, filter)
 
 		entry := models.WaitlistEntry{}
-		err := result.Decode(&entry)
+		err = result.Decode(&entry)
 		if entry.Email != "" {
 			c.AbortWithStatusJSON(http.StatusAlreadyReported, gin.H{"message": "Email already added to waitlist"})
 			return
@@ -58,32 +145,75 @@ func (w *Waitlist) AddToWaitlist() gin.HandlerFunc {
 
 		if err == mongo.ErrNoDocuments {
 			waitlistEntry.Timestamp = time.Now().Unix()
-			_, err := collection.InsertOne(ctx, waitlistEntry)
+			waitlistEntry.Status = models.WAITING_ENTRY_STATUS
+			waitlistEntry.ReferralCode, err = models.NewReferralCode()
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "Unable to generate referral code"})
+				return
+			}
+			// an unknown referral code doesn't stop the signup
+			waitlistEntry.ReferredBy, err = w.findReferrer(ctx, w.scoped(c), list, request.Ref, waitlistEntry.Email)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, "Database error")
+				return
+			}
+			inserted, err := collection.InsertOne(ctx, waitlistEntry)
 			if err != nil {
 				c.AbortWithStatusJSON(http.StatusInternalServerError, "Database error")
 				return
 			}
-			c.JSON(http.StatusOK, gin.H{"message": "User added to waitlist"})
+			waitlistEntry.ID = inserted.InsertedID.(primitive.ObjectID)
+			waitlistEntry.TenantID = list.TenantID
+			w.emit(models.ENTRY_CREATED_EVENT_TYPE, &waitlistEntry)
+			if err := w.rescore(ctx, w.scoped(c), list, bson.M{"_id": waitlistEntry.ID}); err != nil {
+				log.Println("unable to score entry:", err)
+			}
 		} else if err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, "Database error")
 			return
 		}
-		err = w.sendMsg(waitlistEntry.Email, "waitlist-signup", WaitlistAlias)
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "Unable to send email"})
+		data := map[string]string{"ConfirmURL": w.signer.ConfirmURL(waitlistEntry.Email, list.Slug), "ReferralCode": waitlistEntry.ReferralCode}
+		err = w.sendMsg(list, &waitlistEntry, "waitlist-signup", template(list.Templates.Signup, WaitlistAlias), data)
+		if err == emailclient.ErrSuppressed {
+			log.Println("message skipped, recipient suppressed:", waitlistEntry.Email)
+		} else if err != nil {
+			// the entry is stored either way, so its code is still handed back
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "Unable to send email", "referral_code": waitlistEntry.ReferralCode})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"message": "Email sent to waitlist"})
+		if waitlistEntry.Phone != "" {
+			// the email went out, a failed text shouldn't fail the signup
+			if err := w.sendSMS(list, &waitlistEntry, "waitlist-signup", WaitlistSMS); err != nil {
+				log.Println("unable to send sms:", err)
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "User added to waitlist", "referral_code": waitlistEntry.ReferralCode})
 	}
 }
 
 func (w *Waitlist) GetWaitList() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		collection := w.db.Collection("waitlist")
+		collection := w.scoped(c).Collection("waitlist")
 		waitlist := []models.WaitlistEntry{}
 		ctx := context.Background()
 
-		cursor, err := collection.Find(ctx, bson.D{})
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		// custom fields are filtered with fields[key]=value
+		filter, err := fieldFilter(list.Fields, c.QueryMap("fields"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+			return
+		}
+		filter["waitlist"] = list.Slug
+		live(filter)
+		// and tags with tag=value, entries must carry every one
+		tagQuery(filter, c.QueryArray("tag"))
+
+		cursor, err := collection.Find(ctx, filter)
 		if err != nil {
 			log.Println("MongoDv find error:", err)
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "error occured while fetching records"})
@@ -105,10 +235,11 @@ func (w *Waitlist) GetWaitList() gin.HandlerFunc {
 	}
 }
 
-// Delete email from waitlist using URL parameters
+// Delete email from waitlist using URL parameters. The entry is only hidden
+// and can be restored, with its position, until the retention window ends.
 func (w *Waitlist) DeleteFromWaitlist() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		collection := w.db.Collection("waitlist")
+		collection := w.scoped(c).Collection("waitlist")
 		ctx := context.Background()
 
 		// Get the email parameter from the URL
@@ -120,11 +251,18 @@ func (w *Waitlist) DeleteFromWaitlist() gin.HandlerFunc {
 			return
 		}
 
-		filter := bson.M{"email": email}
-		result := collection.FindOne(ctx, filter)
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		now := time.Now()
+		filter := live(bson.M{"waitlist": list.Slug, "email": email})
+		update := bson.M{"$set": bson.M{"deleted_at": now.Unix(), "purge_at": now.AddDate(0, 0, retentionDays(list)).Unix()}}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
 
 		entry := models.WaitlistEntry{}
-		err := result.Decode(&entry)
+		err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&entry)
 		if err == mongo.ErrNoDocuments {
 			c.JSON(http.StatusNotFound, gin.H{"message": "Email not found"})
 			return
@@ -132,40 +270,79 @@ func (w *Waitlist) DeleteFromWaitlist() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
 			return
 		}
+		w.emit(models.ENTRY_DELETED_EVENT_TYPE, &entry)
 
-		_, err = collection.DeleteOne(ctx, filter)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
-			return
-		}
+		before := entry
+		before.DeletedAt, before.PurgeAt = 0, 0
+		auditEntry(c, &before, &entry)
 
-		c.JSON(http.StatusOK, gin.H{"message": "Email deleted from waitlist"})
+		c.JSON(http.StatusOK, gin.H{"message": "Email deleted from waitlist", "purge_at": entry.PurgeAt})
 	}
 }
 
 // send email to waitlist
 
-func (w *Waitlist) sendMsg(Email string, title string, templateID string) error {
+func (w *Waitlist) sendMsg(list *models.Waitlist, entry *models.WaitlistEntry, title string, templateID string, data map[string]string) error {
 
 	// Creating Message
 	message := models.Message{
-		Target:     Email,
-		Type:       "email",
+		CustomerID: entry.ID.Hex(),
+		AccountID:  list.TenantID,
+		Waitlist:   list.Slug,
+		Sender:     list.SenderEmail,
+		Target:     entry.Email,
+		Type:       models.EMAIL_MESSAGE_TYPE,
 		Title:      title,
 		TemplateID: templateID,
 		DataMap:    map[string]string{},
 	}
-	message.DataMap["Email"] = Email
+	for key, value := range data {
+		message.DataMap[key] = value
+		if contains(secretData, key) {
+			message.Secrets = append(message.Secrets, value)
+		}
+	}
+	message.DataMap["Email"] = entry.Email
+	message.DataMap["Waitlist"] = list.Name
 
-	// send message
+	// send message, suppressed recipients are reported to the caller
 	fmt.Println("about send email")
-	if err := w.emailclient.Send(&message); err != nil {
+	if err := w.notifier.Send(&message); err != nil {
 		return err
 	}
 	fmt.Println("email sent")
 	return nil
 }
 
+// sendSMS texts body to the phone number of entry, keeping secrets out of the message log
+func (w *Waitlist) sendSMS(list *models.Waitlist, entry *models.WaitlistEntry, title string, body string, secrets ...string) error {
+	if !w.notifier.Supports(models.SMS_MESSAGE_TYPE) {
+		return nil
+	}
+
+	message := models.Message{
+		CustomerID: entry.ID.Hex(),
+		AccountID:  list.TenantID,
+		Waitlist:   list.Slug,
+		Target:     entry.Phone,
+		Type:       models.SMS_MESSAGE_TYPE,
+		Title:      title,
+		Body:       body,
+		Secrets:    secrets,
+	}
+	return w.notify(&message)
+}
+
+// notify sends message on its channel. Suppressed recipients are skipped, not failed.
+func (w *Waitlist) notify(message *models.Message) error {
+	err := w.notifier.Send(message)
+	if err == emailclient.ErrSuppressed {
+		log.Println("message skipped, recipient suppressed:", message.Target)
+		return nil
+	}
+	return err
+}
+
 func (w *Waitlist) Signin() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		collection := w.db.Collection("admin")
@@ -198,7 +375,20 @@ func (w *Waitlist) Signin() gin.HandlerFunc {
 			return
 		}
 
-		token, err := w.auth.GenerateJWT(userDetails.Email)
+		// act for the requested organization, or the first one the admin belongs to
+		organization := ""
+		if len(userDetails.Organizations) > 0 {
+			organization = userDetails.Organizations[0]
+		}
+		if user.Organization != "" {
+			if !contains(userDetails.Organizations, user.Organization) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "not a member of this organization"})
+				return
+			}
+			organization = user.Organization
+		}
+
+		token, err := w.auth.GenerateJWT(userDetails.Email, organization)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to generate token"})
 			return
@@ -230,12 +420,19 @@ func (w *Waitlist) CreateAdmin() gin.HandlerFunc {
 		}
 
 		user.Password = string(hashPasswrd)
+		// new admins join organizations by creating one or being added by a member
+		user.Organizations = nil
 
-		_, err = collection.InsertOne(ctx, user)
+		result, err := collection.InsertOne(ctx, user)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to write to database", "message": err.Error()})
 			return
 		}
+		// the new admin is the actor, nobody is signed in yet
+		c.Set(middleware.EmailKey, user.Email)
+		if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+			middleware.AuditTarget(c, "admin="+id.Hex())
+		}
 
 		c.JSON(http.StatusAccepted, gin.H{"message": "successfully created admin"})
 	}
@@ -0,0 +1,339 @@
+package controllers
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"waitlist/lib/tenant"
+	"waitlist/middleware"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// errWaitlistNotFound is returned when no waitlist has the requested slug
+var errWaitlistNotFound = errors.New("waitlist not found")
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+type waitlistRequest struct {
+	Slug        string                   `json:"slug"`
+	Name        string                   `json:"name"`
+	SenderEmail string                   `json:"sender_email"`
+	Templates   models.WaitlistTemplates `json:"templates"`
+	Settings    models.WaitlistSettings  `json:"settings"`
+	Fields      []models.SignupField     `json:"fields"`
+}
+
+func (w *Waitlist) CreateWaitlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		collection := w.scoped(c).Collection("waitlists")
+		ctx := context.Background()
+
+		request := waitlistRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+		if !slugPattern.MatchString(request.Slug) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "slug must be lowercase letters, digits and dashes"})
+			return
+		}
+		if request.Name == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+			return
+		}
+		if msg := validateFields(request.Fields); msg != "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		request.Settings.PriorityTags = models.NormalizeTags(request.Settings.PriorityTags)
+
+		list := models.Waitlist{
+			TenantID:    c.GetString(middleware.TenantKey),
+			Slug:        request.Slug,
+			Name:        request.Name,
+			SenderEmail: request.SenderEmail,
+			Templates:   request.Templates,
+			Settings:    request.Settings,
+			Fields:      request.Fields,
+			CreatedAt:   time.Now().Unix(),
+		}
+		result, err := collection.InsertOne(ctx, list)
+		if mongo.IsDuplicateKeyError(err) {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "a waitlist with this slug already exists"})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to write to database", "message": err.Error()})
+			return
+		}
+		list.ID = result.InsertedID.(primitive.ObjectID)
+		middleware.AuditTarget(c, "slug="+list.Slug)
+		middleware.AuditState(c, nil, list)
+
+		c.JSON(http.StatusCreated, list)
+	}
+}
+
+func (w *Waitlist) GetWaitlists() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+		cursor, err := w.scoped(c).Collection("waitlists").Find(ctx, bson.M{}, opts)
+		if err != nil {
+			log.Println("MongoDb find error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching waitlists"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		lists := []models.Waitlist{}
+		if err := cursor.All(ctx, &lists); err != nil {
+			log.Println("MongoDb decode error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error decoding document"})
+			return
+		}
+
+		c.JSON(http.StatusOK, lists)
+	}
+}
+
+func (w *Waitlist) GetWaitlistConfig() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, list)
+	}
+}
+
+// Update the name, sender, templates, settings and signup fields of a waitlist.
+// The slug can't change. Values of removed fields stay on existing entries.
+func (w *Waitlist) UpdateWaitlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		collection := w.scoped(c).Collection("waitlists")
+		ctx := context.Background()
+
+		request := waitlistRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+		if request.Name == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+			return
+		}
+		if msg := validateFields(request.Fields); msg != "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		request.Settings.PriorityTags = models.NormalizeTags(request.Settings.PriorityTags)
+
+		update := bson.M{"$set": bson.M{
+			"name":         request.Name,
+			"sender_email": request.SenderEmail,
+			"templates":    request.Templates,
+			"settings":     request.Settings,
+			"fields":       request.Fields,
+		}}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+
+		before := models.Waitlist{}
+		err := collection.FindOneAndUpdate(ctx, bson.M{"slug": c.Param("slug")}, update, opts).Decode(&before)
+		if err == mongo.ErrNoDocuments {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Waitlist not found"})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		list := before
+		list.Name = request.Name
+		list.SenderEmail = request.SenderEmail
+		list.Templates = request.Templates
+		list.Settings = request.Settings
+		list.Fields = request.Fields
+		middleware.AuditState(c, before, list)
+
+		// reorders only the entries out of step with the priority tags
+		if err := syncPriority(ctx, w.scoped(c).Collection("waitlist"), &list, bson.M{}); err != nil {
+			log.Println("unable to reorder waitlist:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, list)
+	}
+}
+
+// Export the entries of a waitlist as CSV, in queue order, with one column per
+// custom field. Entries can be filtered like GetWaitList.
+func (w *Waitlist) ExportWaitlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		list, ok := w.loadWaitlist(c)
+		if !ok {
+			return
+		}
+
+		filter, err := fieldFilter(list.Fields, c.QueryMap("fields"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filter["waitlist"] = list.Slug
+		live(filter)
+		tagQuery(filter, c.QueryArray("tag"))
+
+		settings, err := w.scoringSettings(ctx, w.scoped(c), list.Slug)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		opts := options.Find().SetSort(queueOrder(settings.Enabled))
+		cursor, err := w.scoped(c).Collection("waitlist").Find(ctx, filter, opts)
+		if err != nil {
+			log.Println("MongoDb find error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching records"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", `attachment; filename="`+list.Slug+`.csv"`)
+		c.Status(http.StatusOK)
+
+		writer := csv.NewWriter(c.Writer)
+		writer.Write(exportHeader(list))
+		for cursor.Next(ctx) {
+			var entry models.WaitlistEntry
+			if err := cursor.Decode(&entry); err != nil {
+				// headers are already sent, all we can do is stop
+				log.Println("MongoDb decode error", err)
+				break
+			}
+			writer.Write(exportRow(list, &entry))
+		}
+		writer.Flush()
+	}
+}
+
+func exportHeader(list *models.Waitlist) []string {
+	header := []string{"email", "phone", "status", "signed_up_at", "confirmed_at", "invited_at", "accepted_at", "opt_out", "referral_count", "tags"}
+	for _, field := range list.Fields {
+		header = append(header, field.Key)
+	}
+	return header
+}
+
+func exportRow(list *models.Waitlist, entry *models.WaitlistEntry) []string {
+	row := []string{
+		entry.Email,
+		entry.Phone,
+		string(entry.Status),
+		formatUnix(entry.Timestamp),
+		formatUnix(entry.ConfirmedAt),
+		formatUnix(entry.InvitedAt),
+		formatUnix(entry.AcceptedAt),
+		string(entry.OptOut),
+		strconv.Itoa(entry.ReferralCount),
+		strings.Join(entry.Tags, ";"),
+	}
+	for _, field := range list.Fields {
+		row = append(row, formatField(entry.Metadata[field.Key]))
+	}
+	return row
+}
+
+// formatUnix renders a unix timestamp as RFC 3339, leaving unset timestamps empty
+func formatUnix(ts int64) string {
+	if ts == 0 {
+		return ""
+	}
+	return time.Unix(ts, 0).UTC().Format(time.RFC3339)
+}
+
+// waitlistSlug returns the waitlist a request targets: the :slug path
+// parameter, then the waitlist query parameter, then the default waitlist
+func waitlistSlug(c *gin.Context) string {
+	if slug := c.Param("slug"); slug != "" {
+		return slug
+	}
+	if slug := c.Query("waitlist"); slug != "" {
+		return slug
+	}
+	return models.DefaultWaitlist
+}
+
+// loadWaitlist finds the waitlist targeted by the request in the admin's
+// organization, aborting when it doesn't exist
+func (w *Waitlist) loadWaitlist(c *gin.Context) (*models.Waitlist, bool) {
+	list, err := w.findWaitlist(context.Background(), w.scoped(c), waitlistSlug(c))
+	return list, abortOnWaitlistError(c, err)
+}
+
+// loadPublicWaitlist finds the waitlist targeted by an unauthenticated request.
+// Slugs are unique across organizations, so the waitlist decides which tenant
+// the rest of the request is scoped to.
+func (w *Waitlist) loadPublicWaitlist(c *gin.Context) (*models.Waitlist, bool) {
+	list := models.Waitlist{}
+	err := w.db.Collection("waitlists").FindOne(context.Background(), bson.M{"slug": waitlistSlug(c)}).Decode(&list)
+	if err == mongo.ErrNoDocuments {
+		err = errWaitlistNotFound
+	}
+	if !abortOnWaitlistError(c, err) {
+		return nil, false
+	}
+	c.Set(middleware.TenantKey, list.TenantID)
+	return &list, true
+}
+
+// abortOnWaitlistError aborts the request when a waitlist lookup failed and reports whether it succeeded
+func abortOnWaitlistError(c *gin.Context, err error) bool {
+	if err == errWaitlistNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Waitlist not found"})
+		return false
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+		return false
+	}
+	return true
+}
+
+func (w *Waitlist) findWaitlist(ctx context.Context, db *tenant.Database, slug string) (*models.Waitlist, error) {
+	if slug == "" {
+		slug = models.DefaultWaitlist
+	}
+
+	list := models.Waitlist{}
+	err := db.Collection("waitlists").FindOne(ctx, bson.M{"slug": slug}).Decode(&list)
+	if err == mongo.ErrNoDocuments {
+		return nil, errWaitlistNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// template returns alias, or fallback when the waitlist doesn't override it
+func template(alias, fallback string) string {
+	if alias == "" {
+		return fallback
+	}
+	return alias
+}
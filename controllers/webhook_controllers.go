@@ -0,0 +1,337 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+	"waitlist/lib/tenant"
+	"waitlist/lib/webhooks"
+	"waitlist/middleware"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type webhookRequest struct {
+	URL    string             `json:"url"`
+	Events []models.EventType `json:"events"`
+	Active *bool              `json:"active"`
+}
+
+// validate checks the URL and event types and returns a message for the caller
+func (r *webhookRequest) validate() string {
+	target, err := url.Parse(r.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return "url must be an absolute http or https URL"
+	}
+	if len(r.Events) == 0 {
+		return "at least one event is required"
+	}
+	for _, event := range r.Events {
+		if !event.Valid() {
+			return "unknown event " + string(event)
+		}
+	}
+	return ""
+}
+
+// webhookPayload is the JSON body posted to webhooks
+type webhookPayload struct {
+	ID        string           `json:"id"`
+	Type      models.EventType `json:"type"`
+	CreatedAt int64            `json:"created_at"`
+	Data      webhookData      `json:"data"`
+}
+
+type webhookData struct {
+	Waitlist string                `json:"waitlist"`
+	Email    string                `json:"email"`
+	Entry    *models.WaitlistEntry `json:"entry,omitempty"`
+	Invite   *models.Invite        `json:"invite,omitempty"`
+}
+
+// Register a webhook. The signing secret is only ever returned here.
+func (w *Waitlist) CreateWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		request := webhookRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+		if msg := request.validate(); msg != "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		secret, err := webhooks.NewSecret()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to generate secret"})
+			return
+		}
+		hook := models.Webhook{
+			URL:       request.URL,
+			Events:    request.Events,
+			Secret:    secret,
+			Active:    request.Active == nil || *request.Active,
+			CreatedAt: time.Now().Unix(),
+		}
+		result, err := w.scoped(c).Collection("webhooks").InsertOne(context.Background(), hook)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to write to database", "message": err.Error()})
+			return
+		}
+		hook.ID = result.InsertedID.(primitive.ObjectID)
+		middleware.AuditTarget(c, "id="+hook.ID.Hex())
+		middleware.AuditState(c, nil, hook)
+
+		c.JSON(http.StatusCreated, gin.H{"webhook": hook, "secret": secret})
+	}
+}
+
+func (w *Waitlist) GetWebhooks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+		cursor, err := w.scoped(c).Collection("webhooks").Find(ctx, bson.M{}, opts)
+		if err != nil {
+			log.Println("MongoDb find error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching webhooks"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		hooks := []models.Webhook{}
+		if err := cursor.All(ctx, &hooks); err != nil {
+			log.Println("MongoDb decode error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error decoding document"})
+			return
+		}
+
+		c.JSON(http.StatusOK, hooks)
+	}
+}
+
+// Update the URL, events and active flag of a webhook. The secret is kept.
+func (w *Waitlist) UpdateWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid webhook id"})
+			return
+		}
+
+		request := webhookRequest{}
+		if err := c.BindJSON(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unable to decode Json payload", "message": err.Error()})
+			return
+		}
+		if msg := request.validate(); msg != "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		set := bson.M{"url": request.URL, "events": request.Events}
+		if request.Active != nil {
+			set["active"] = *request.Active
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+
+		before := models.Webhook{}
+		err = w.scoped(c).Collection("webhooks").FindOneAndUpdate(context.Background(), bson.M{"_id": id}, bson.M{"$set": set}, opts).Decode(&before)
+		if err == mongo.ErrNoDocuments {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Webhook not found"})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		hook := before
+		hook.URL = request.URL
+		hook.Events = request.Events
+		if request.Active != nil {
+			hook.Active = *request.Active
+		}
+		middleware.AuditState(c, before, hook)
+
+		c.JSON(http.StatusOK, hook)
+	}
+}
+
+// Delete a webhook. Pending deliveries fail on their next attempt.
+func (w *Waitlist) DeleteWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid webhook id"})
+			return
+		}
+
+		before := models.Webhook{}
+		err = w.scoped(c).Collection("webhooks").FindOneAndDelete(context.Background(), bson.M{"_id": id}).Decode(&before)
+		if err == mongo.ErrNoDocuments {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Webhook not found"})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+		middleware.AuditState(c, before, nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+	}
+}
+
+// Get the delivery log of a webhook, newest first, optionally filtered by status
+func (w *Waitlist) GetWebhookDeliveries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid webhook id"})
+			return
+		}
+
+		filter := bson.M{"webhook_id": id}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+		limit, skip, err := pagination(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit).SetSkip(skip)
+		cursor, err := w.scoped(c).Collection("webhook_deliveries").Find(ctx, filter, opts)
+		if err != nil {
+			log.Println("MongoDb find error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching deliveries"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		deliveries := []models.WebhookDelivery{}
+		if err := cursor.All(ctx, &deliveries); err != nil {
+			log.Println("MongoDb decode error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error decoding document"})
+			return
+		}
+
+		c.JSON(http.StatusOK, deliveries)
+	}
+}
+
+// Queue a fresh copy of a logged delivery, keeping the original in the log
+func (w *Waitlist) RedeliverWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+		collection := w.scoped(c).Collection("webhook_deliveries")
+
+		hookID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid webhook id"})
+			return
+		}
+		id, err := primitive.ObjectIDFromHex(c.Param("delivery"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid delivery id"})
+			return
+		}
+
+		original := models.WebhookDelivery{}
+		err = collection.FindOne(ctx, bson.M{"_id": id, "webhook_id": hookID}).Decode(&original)
+		if err == mongo.ErrNoDocuments {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Delivery not found"})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Database error"})
+			return
+		}
+
+		delivery := redelivery(&original, time.Now().Unix())
+		result, err := collection.InsertOne(ctx, delivery)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to write to database", "message": err.Error()})
+			return
+		}
+		delivery.ID = result.InsertedID.(primitive.ObjectID)
+
+		c.JSON(http.StatusAccepted, delivery)
+	}
+}
+
+// redelivery returns a fresh copy of original, due now
+func redelivery(original *models.WebhookDelivery, now int64) models.WebhookDelivery {
+	return models.WebhookDelivery{
+		WebhookID:     original.WebhookID,
+		EventID:       original.EventID,
+		Event:         original.Event,
+		Email:         original.Email,
+		Payload:       original.Payload,
+		Status:        models.PENDING_DELIVERY_STATUS,
+		NextAttemptAt: now,
+		RedeliveryOf:  original.ID,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+}
+
+// dispatch queues a delivery of event to every active webhook of its
+// organization subscribed to its type. Failures are logged, the action that
+// raised the event has already happened.
+func (w *Waitlist) dispatch(ctx context.Context, event models.Event) {
+	db := tenant.Scope(w.db, event.TenantID)
+	cursor, err := db.Collection("webhooks").Find(ctx, bson.M{"active": true, "events": event.Type})
+	if err != nil {
+		log.Println("MongoDb find error:", err)
+		return
+	}
+	hooks := []models.Webhook{}
+	if err := cursor.All(ctx, &hooks); err != nil {
+		log.Println("MongoDb decode error", err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	now := time.Now().Unix()
+	eventID := primitive.NewObjectID().Hex()
+	payload, err := json.Marshal(webhookPayload{
+		ID:        eventID,
+		Type:      event.Type,
+		CreatedAt: now,
+		Data:      webhookData{Waitlist: event.Waitlist, Email: event.Email, Entry: event.Entry.Published(), Invite: event.Invite},
+	})
+	if err != nil {
+		log.Println("unable to encode webhook payload:", err)
+		return
+	}
+
+	deliveries := make([]interface{}, len(hooks))
+	for i, hook := range hooks {
+		deliveries[i] = models.WebhookDelivery{
+			WebhookID:     hook.ID,
+			EventID:       eventID,
+			Event:         event.Type,
+			Email:         event.Email,
+			Payload:       string(payload),
+			Status:        models.PENDING_DELIVERY_STATUS,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+	}
+	if _, err := db.Collection("webhook_deliveries").InsertMany(ctx, deliveries); err != nil {
+		log.Println("unable to queue webhook deliveries:", err)
+	}
+}
@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"testing"
+	"waitlist/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRedeliveryQueuesFreshCopy(t *testing.T) {
+	original := models.WebhookDelivery{
+		ID:             primitive.NewObjectID(),
+		WebhookID:      primitive.NewObjectID(),
+		EventID:        "event",
+		Event:          models.ENTRY_CREATED_EVENT_TYPE,
+		Email:          "jane@example.com",
+		Payload:        `{"id":"event"}`,
+		Status:         models.FAILED_DELIVERY_STATUS,
+		Attempts:       8,
+		ResponseStatus: 500,
+		Error:          "receiver answered 500",
+		CreatedAt:      100,
+		UpdatedAt:      200,
+	}
+
+	delivery := redelivery(&original, 300)
+
+	if !delivery.ID.IsZero() {
+		t.Errorf("id = %s, want a new document", delivery.ID.Hex())
+	}
+	if delivery.RedeliveryOf != original.ID {
+		t.Errorf("redelivery_of = %s, want %s", delivery.RedeliveryOf.Hex(), original.ID.Hex())
+	}
+	if delivery.WebhookID != original.WebhookID || delivery.EventID != original.EventID || delivery.Event != original.Event ||
+		delivery.Email != original.Email || delivery.Payload != original.Payload {
+		t.Errorf("delivery %+v doesn't copy %+v", delivery, original)
+	}
+	if delivery.Status != models.PENDING_DELIVERY_STATUS || delivery.Attempts != 0 || delivery.NextAttemptAt != 300 {
+		t.Errorf("delivery %+v isn't queued for now", delivery)
+	}
+	if delivery.Error != "" || delivery.ResponseStatus != 0 || delivery.DeliveredAt != 0 {
+		t.Errorf("delivery %+v keeps the outcome of the original", delivery)
+	}
+	if delivery.CreatedAt != 300 || delivery.UpdatedAt != 300 {
+		t.Errorf("delivery %+v has the timestamps of the original", delivery)
+	}
+}
@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"time"
+	"waitlist/lib/tenant"
+	"waitlist/lib/webhooks"
+	"waitlist/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const webhookInterval = 5 * time.Second
+
+// RunWebhooks delivers queued webhook payloads until ctx is done
+func (w *Waitlist) RunWebhooks(ctx context.Context) {
+	ticker := time.NewTicker(webhookInterval)
+	defer ticker.Stop()
+
+	for {
+		w.deliverWebhooks(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverWebhooks drains the due deliveries of every organization, claiming
+// each one so replicas never post it twice. Failures are retried with backoff
+// until webhooks.MaxAttempts.
+func (w *Waitlist) deliverWebhooks(ctx context.Context) {
+	queue := w.db.Collection("webhook_deliveries")
+
+	stale := bson.M{
+		"status":     models.DELIVERING_DELIVERY_STATUS,
+		"updated_at": bson.M{"$lt": time.Now().Add(-sendClaimTimeout).Unix()},
+	}
+	requeue := bson.M{"$set": bson.M{"status": models.PENDING_DELIVERY_STATUS}}
+	if _, err := queue.UpdateMany(ctx, stale, requeue); err != nil {
+		log.Println("unable to requeue webhook deliveries:", err)
+	}
+
+	for {
+		delivery := models.WebhookDelivery{}
+		filter := bson.M{"status": models.PENDING_DELIVERY_STATUS, "next_attempt_at": bson.M{"$lte": time.Now().Unix()}}
+		claim := bson.M{"$set": bson.M{"status": models.DELIVERING_DELIVERY_STATUS, "updated_at": time.Now().Unix()}}
+		opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).SetReturnDocument(options.After)
+		err := queue.FindOneAndUpdate(ctx, filter, claim, opts).Decode(&delivery)
+		if err == mongo.ErrNoDocuments {
+			return
+		} else if err != nil {
+			log.Println("unable to claim webhook delivery:", err)
+			return
+		}
+
+		set := w.attemptDelivery(ctx, &delivery)
+		if _, err := queue.UpdateOne(ctx, bson.M{"_id": delivery.ID}, bson.M{"$set": set}); err != nil {
+			log.Println("unable to update webhook delivery:", err)
+			return
+		}
+	}
+}
+
+// attemptDelivery posts delivery to its webhook and returns the fields recording the outcome
+func (w *Waitlist) attemptDelivery(ctx context.Context, delivery *models.WebhookDelivery) bson.M {
+	now := time.Now()
+	set := bson.M{"updated_at": now.Unix()}
+
+	hook := models.Webhook{}
+	err := tenant.Scope(w.db, delivery.TenantID).Collection("webhooks").FindOne(ctx, bson.M{"_id": delivery.WebhookID}).Decode(&hook)
+	if err == mongo.ErrNoDocuments || (err == nil && !hook.Active) {
+		set["status"] = models.FAILED_DELIVERY_STATUS
+		set["error"] = "webhook was deleted or disabled"
+		return set
+	} else if err != nil {
+		// the receiver was never contacted, so this doesn't count as an attempt,
+		// but waiting a tick keeps the drain loop from reclaiming it right away
+		log.Println("unable to load webhook for delivery:", delivery.ID.Hex(), err)
+		set["status"] = models.PENDING_DELIVERY_STATUS
+		set["next_attempt_at"] = now.Add(webhookInterval).Unix()
+		return set
+	}
+
+	status, sendErr := w.hooks.Deliver(hook.URL, hook.Secret, delivery)
+	return attemptOutcome(delivery.Attempts+1, status, sendErr, now)
+}
+
+// attemptOutcome returns the fields recording the given attempt of a delivery,
+// scheduling a retry with backoff until webhooks.MaxAttempts
+func attemptOutcome(attempts int, status int, sendErr error, now time.Time) bson.M {
+	set := bson.M{"updated_at": now.Unix(), "attempts": attempts, "response_status": status}
+	switch {
+	case sendErr == nil:
+		set["status"] = models.DELIVERED_DELIVERY_STATUS
+		set["error"] = ""
+		set["delivered_at"] = now.Unix()
+	case attempts >= webhooks.MaxAttempts:
+		set["status"] = models.FAILED_DELIVERY_STATUS
+		set["error"] = sendErr.Error()
+	default:
+		set["status"] = models.PENDING_DELIVERY_STATUS
+		set["error"] = sendErr.Error()
+		set["next_attempt_at"] = now.Add(webhooks.Backoff(attempts)).Unix()
+	}
+	return set
+}
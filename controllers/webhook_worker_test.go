@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"waitlist/lib/webhooks"
+	"waitlist/models"
+)
+
+func TestAttemptOutcomeRetriesThenFails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	sender := webhooks.New(server.Client())
+
+	now := time.Unix(1700000000, 0)
+	delivery := &models.WebhookDelivery{Payload: "{}"}
+	for attempts := 1; attempts <= webhooks.MaxAttempts; attempts++ {
+		status, err := sender.Deliver(server.URL, "whsec_test", delivery)
+		set := attemptOutcome(attempts, status, err, now)
+
+		if set["attempts"] != attempts || set["response_status"] != http.StatusServiceUnavailable {
+			t.Errorf("attempt %d: recorded %v", attempts, set)
+		}
+		if set["error"] == "" {
+			t.Errorf("attempt %d: no error recorded", attempts)
+		}
+		if attempts < webhooks.MaxAttempts {
+			if set["status"] != models.PENDING_DELIVERY_STATUS {
+				t.Errorf("attempt %d: status = %v, want pending", attempts, set["status"])
+			}
+			if want := now.Add(webhooks.Backoff(attempts)).Unix(); set["next_attempt_at"] != want {
+				t.Errorf("attempt %d: next_attempt_at = %v, want %d", attempts, set["next_attempt_at"], want)
+			}
+			continue
+		}
+		if set["status"] != models.FAILED_DELIVERY_STATUS {
+			t.Errorf("attempt %d: status = %v, want failed", attempts, set["status"])
+		}
+		if _, ok := set["next_attempt_at"]; ok {
+			t.Errorf("attempt %d: a failed delivery was rescheduled", attempts)
+		}
+	}
+}
+
+func TestAttemptOutcomeDelivered(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	set := attemptOutcome(3, http.StatusOK, nil, now)
+	if set["status"] != models.DELIVERED_DELIVERY_STATUS || set["delivered_at"] != now.Unix() || set["error"] != "" {
+		t.Errorf("recorded %v, want delivered", set)
+	}
+}
@@ -27,5 +27,7 @@ func ConnectDatabase() *mongo.Database {
 	}
 
 	db = client.Database(dbName)
+	ensureIndexes(ctx, db)
+	migrate(ctx, db)
 	return db
 }
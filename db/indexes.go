@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexes lists the indexes each collection needs, keyed by collection name
+var indexes = map[string][]mongo.IndexModel{
+	"campaign_recipients": {
+		{
+			Keys:    bson.D{{Key: "campaign_id", Value: 1}, {Key: "email", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "campaign_id", Value: 1}, {Key: "status", Value: 1}}},
+	},
+	"drip_messages": {
+		{
+			Keys:    bson.D{{Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "email", Value: 1}, {Key: "sequence_id", Value: 1}, {Key: "step_key", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}}},
+	},
+	"invites": {
+		{
+			Keys:    bson.D{{Key: "code_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "entry_id", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expires_at", Value: 1}}},
+	},
+	"waitlist": {
+		{Keys: bson.D{{Key: "waitlist", Value: 1}, {Key: "email", Value: 1}}},
+		{Keys: bson.D{{Key: "email", Value: 1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "email", Value: 1}}},
+		{Keys: bson.D{{Key: "waitlist", Value: 1}, {Key: "status", Value: 1}, {Key: "timestamp", Value: 1}}},
+		{Keys: bson.D{{Key: "waitlist", Value: 1}, {Key: "status", Value: 1}, {Key: "priority", Value: -1}, {Key: "timestamp", Value: 1}}},
+		{Keys: bson.D{{Key: "waitlist", Value: 1}, {Key: "status", Value: 1}, {Key: "priority", Value: -1}, {Key: "score", Value: -1}, {Key: "timestamp", Value: 1}}},
+		{Keys: bson.D{{Key: "waitlist", Value: 1}, {Key: "tags", Value: 1}}},
+		{Keys: bson.D{{Key: "waitlist", Value: 1}, {Key: "timestamp", Value: 1}}},
+		{Keys: bson.D{{Key: "purge_at", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "referral_code", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		{Keys: bson.D{{Key: "referred_by", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "waitlist", Value: 1}, {Key: "referral_count", Value: -1}, {Key: "timestamp", Value: 1}}},
+	},
+	"admin": {
+		{Keys: bson.D{{Key: "email", Value: 1}}},
+	},
+	"waitlists": {
+		{
+			Keys:    bson.D{{Key: "slug", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	},
+	"milestones": {
+		{
+			Keys:    bson.D{{Key: "waitlist", Value: 1}, {Key: "threshold", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	},
+	"privacy_requests": {
+		{
+			Keys:    bson.D{{Key: "token_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "email_hash", Value: 1}}},
+	},
+	"bulk_jobs": {
+		{Keys: bson.D{{Key: "state", Value: 1}, {Key: "created_at", Value: 1}}},
+	},
+	"bulk_items": {
+		{Keys: bson.D{{Key: "job_id", Value: 1}, {Key: "result", Value: 1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "email", Value: 1}}},
+	},
+	"referral_rewards": {
+		{
+			Keys:    bson.D{{Key: "waitlist", Value: 1}, {Key: "entry_id", Value: 1}, {Key: "tier", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "earned_at", Value: 1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "email", Value: 1}}},
+	},
+	"webhooks": {
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "events", Value: 1}}},
+	},
+	"webhook_deliveries": {
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "webhook_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "email", Value: 1}}},
+	},
+}
+
+// ensureIndexes creates any missing index. Failures are logged rather than fatal
+// so the API can still serve requests against an existing database.
+func ensureIndexes(ctx context.Context, db *mongo.Database) {
+	for collection, models := range indexes {
+		if _, err := db.Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+			log.Println("unable to create indexes for", collection, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+	"waitlist/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// backfillBatchSize is how many documents a backfill writes per call
+const backfillBatchSize = 500
+
+// defaultWaitlist mirrors models.DefaultWaitlist, the list served by the unscoped routes
+const defaultWaitlist = "default"
+
+// defaultTenant mirrors tenant.Default, the organization owning data from before organizations
+const defaultTenant = "default"
+
+// tenantCollections hold documents scoped by tenant_id
+var tenantCollections = []string{
+	"admission_runs", "admission_settings", "campaign_recipients", "campaigns", "drip_messages",
+	"drip_sequences", "invite_waves", "invites", "suppressions", "waitlist", "waitlists",
+}
+
+// migrate backfills documents written before a feature existed. Every step is
+// idempotent so it runs on each start.
+func migrate(ctx context.Context, db *mongo.Database) {
+	// entries from before named waitlists belong to the default one
+	filter := bson.M{"slug": defaultWaitlist}
+	update := bson.M{"$setOnInsert": bson.M{"slug": defaultWaitlist, "name": "Default", "created_at": time.Now().Unix()}}
+	if _, err := db.Collection("waitlists").UpdateOne(ctx, filter, update, options.Update().SetUpsert(true)); err != nil {
+		log.Println("unable to create default waitlist:", err)
+	}
+	for _, collection := range []string{"waitlist", "invites", "drip_sequences"} {
+		filter := bson.M{"waitlist": bson.M{"$exists": false}}
+		update := bson.M{"$set": bson.M{"waitlist": defaultWaitlist}}
+		if _, err := db.Collection(collection).UpdateMany(ctx, filter, update); err != nil {
+			log.Println("unable to backfill waitlist on", collection, err)
+		}
+	}
+
+	// everything from before organizations belongs to the default one, and so do its admins
+	filter = bson.M{"_id": defaultTenant}
+	update = bson.M{"$setOnInsert": bson.M{"name": "Default", "created_at": time.Now().Unix()}}
+	if _, err := db.Collection("organizations").UpdateOne(ctx, filter, update, options.Update().SetUpsert(true)); err != nil {
+		log.Println("unable to create default organization:", err)
+	}
+	filter = bson.M{"organizations": bson.M{"$exists": false}}
+	update = bson.M{"$set": bson.M{"organizations": bson.A{defaultTenant}}}
+	if _, err := db.Collection("admin").UpdateMany(ctx, filter, update); err != nil {
+		log.Println("unable to backfill organizations on admins:", err)
+	}
+	for _, collection := range tenantCollections {
+		filter := bson.M{"tenant_id": bson.M{"$exists": false}}
+		update := bson.M{"$set": bson.M{"tenant_id": defaultTenant}}
+		if _, err := db.Collection(collection).UpdateMany(ctx, filter, update); err != nil {
+			log.Println("unable to backfill tenant on", collection, err)
+		}
+	}
+	filter = bson.M{"account_id": bson.M{"$in": bson.A{nil, ""}}}
+	update = bson.M{"$set": bson.M{"account_id": defaultTenant}}
+	if _, err := db.Collection("messages").UpdateMany(ctx, filter, update); err != nil {
+		log.Println("unable to backfill account on messages:", err)
+	}
+
+	// entries from before referrals get a code to share
+	if err := backfillReferralCodes(ctx, db.Collection("waitlist")); err != nil {
+		log.Println("unable to backfill referral codes:", err)
+	}
+}
+
+// backfillReferralCodes gives a referral code to every entry without one.
+// Entries whose code collides keep none until the next start.
+func backfillReferralCodes(ctx context.Context, collection *mongo.Collection) error {
+	filter := bson.M{"referral_code": bson.M{"$exists": false}}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}).SetBatchSize(backfillBatchSize))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	writes := []mongo.WriteModel{}
+	flush := func() error {
+		if len(writes) == 0 {
+			return nil
+		}
+		_, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+		writes = writes[:0]
+		var bulkErr mongo.BulkWriteException
+		if errors.As(err, &bulkErr) {
+			log.Println("unable to backfill some referral codes:", err)
+			return nil
+		}
+		return err
+	}
+
+	for cursor.Next(ctx) {
+		entry := struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}{}
+		if err := cursor.Decode(&entry); err != nil {
+			return err
+		}
+		code, err := models.NewReferralCode()
+		if err != nil {
+			return err
+		}
+		update := bson.M{"$set": bson.M{"referral_code": code}}
+		writes = append(writes, mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": entry.ID, "referral_code": bson.M{"$exists": false}}).SetUpdate(update))
+		if len(writes) == backfillBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
@@ -0,0 +1,143 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"waitlist/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// appendAttempts bounds the retries when concurrent writers race for the next sequence number
+const appendAttempts = 10
+
+// ErrContention is returned when a record couldn't be chained after appendAttempts
+var ErrContention = errors.New("audit log is too busy, record not appended")
+
+// Log is the append-only audit_log collection. It has no update or delete methods on purpose.
+type Log struct {
+	collection *mongo.Collection
+}
+
+// Verification is the result of walking the chain of one organization
+type Verification struct {
+	Records int64  `json:"records"`
+	Valid   bool   `json:"valid"`
+	Head    string `json:"head,omitempty"`
+	// BrokenAt is the sequence number of the first record that doesn't fit the chain
+	BrokenAt int64  `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// New returns a Log backed by the audit_log collection of db
+func New(db *mongo.Database) *Log {
+	collection := db.Collection("audit_log")
+
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "seq", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "actor", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "timestamp", Value: -1}}},
+	}
+	if _, err := collection.Indexes().CreateMany(context.Background(), indexes); err != nil {
+		log.Println("unable to create audit_log indexes:", err)
+	}
+
+	return &Log{collection: collection}
+}
+
+// Append chains record to the last record of its organization and stores it.
+// The unique sequence index makes concurrent writers on other replicas retry
+// rather than fork the chain.
+func (l *Log) Append(ctx context.Context, record *models.AuditRecord) error {
+	for attempt := 0; attempt < appendAttempts; attempt++ {
+		last := models.AuditRecord{}
+		opts := options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})
+		err := l.collection.FindOne(ctx, bson.M{"tenant_id": record.TenantID}, opts).Decode(&last)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		record.Seq = last.Seq + 1
+		record.PrevHash = last.Hash
+		record.Hash = Digest(record)
+
+		_, err = l.collection.InsertOne(ctx, record)
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		return err
+	}
+	return ErrContention
+}
+
+// Verify walks the chain of tenant from the first record and reports the
+// first one whose sequence, link or hash doesn't match
+func (l *Log) Verify(ctx context.Context, tenant string) (*Verification, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}})
+	cursor, err := l.collection.Find(ctx, bson.M{"tenant_id": tenant}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	result := Verification{Valid: true}
+	previous := models.AuditRecord{}
+	for cursor.Next(ctx) {
+		record := models.AuditRecord{}
+		if err := cursor.Decode(&record); err != nil {
+			return nil, err
+		}
+		result.Records++
+
+		switch {
+		case record.Seq != previous.Seq+1:
+			result.Reason = fmt.Sprintf("expected record %d, found %d", previous.Seq+1, record.Seq)
+		case record.PrevHash != previous.Hash:
+			result.Reason = "link to the previous record doesn't match"
+		case record.Hash != Digest(&record):
+			result.Reason = "content doesn't match its hash"
+		}
+		if result.Reason != "" {
+			result.Valid = false
+			result.BrokenAt = previous.Seq + 1
+			return &result, nil
+		}
+		previous = record
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	result.Head = previous.Hash
+	return &result, nil
+}
+
+// Digest returns the hex SHA-256 of the content of record and the hash of the
+// record before it. The hash and the database id are not part of the content.
+func Digest(record *models.AuditRecord) string {
+	content, _ := json.Marshal(struct {
+		TenantID  string          `json:"tenant_id"`
+		Seq       int64           `json:"seq"`
+		Actor     string          `json:"actor"`
+		Action    string          `json:"action"`
+		Target    string          `json:"target"`
+		RequestID string          `json:"request_id"`
+		IP        string          `json:"ip"`
+		Status    int             `json:"status"`
+		Before    json.RawMessage `json:"before,omitempty"`
+		After     json.RawMessage `json:"after,omitempty"`
+		Timestamp int64           `json:"timestamp"`
+		PrevHash  string          `json:"prev_hash"`
+	}{
+		record.TenantID, record.Seq, record.Actor, record.Action, record.Target, record.RequestID,
+		record.IP, record.Status, record.Before, record.After, record.Timestamp, record.PrevHash,
+	})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
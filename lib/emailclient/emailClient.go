@@ -6,3 +6,32 @@ import "waitlist/models"
 type EmailClient interface {
 	Send(email *models.Message) error
 }
+
+// BatchSender is implemented by email clients that can submit many messages in one call
+type BatchSender interface {
+	SendBatch(emails []*models.Message) []SendResult
+}
+
+// SendResult is the outcome of one message of a batch, at the same index as its input
+type SendResult struct {
+	Target    string
+	MessageID string
+	Err       error
+}
+
+// SendBatch sends emails through client, in one call when it implements BatchSender
+// and one by one otherwise
+func SendBatch(client EmailClient, emails []*models.Message) []SendResult {
+	if batch, ok := client.(BatchSender); ok {
+		return batch.SendBatch(emails)
+	}
+
+	results := make([]SendResult, len(emails))
+	for i, email := range emails {
+		results[i].Err = client.Send(email)
+		if email != nil {
+			results[i].Target = email.Target
+		}
+	}
+	return results
+}
@@ -0,0 +1,106 @@
+package postmark
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"waitlist/lib/emailclient"
+	"waitlist/models"
+)
+
+// Ensure implementation of EmailClient and BatchSender interfaces
+var _ emailclient.EmailClient = (*accountClient)(nil)
+var _ emailclient.BatchSender = (*accountClient)(nil)
+
+// AccountLookup returns the server token and default sender of an account.
+// Empty values fall back to POSTMARK_KEY and PLATFORM_EMAIL.
+type AccountLookup func(account string) (token string, sender string, err error)
+
+// accountClient sends every message through the Postmark server of its AccountID
+type accountClient struct {
+	baseURL string
+	lookup  AccountLookup
+
+	mu      sync.Mutex
+	clients map[[2]string]*emailClient
+}
+
+// NewPerAccount returns an EmailClient calling the API at baseURL that sends
+// each message with the server token and sender identity of the account it
+// belongs to
+func NewPerAccount(baseURL string, lookup AccountLookup) emailclient.EmailClient {
+	return &accountClient{baseURL: baseURL, lookup: lookup, clients: map[[2]string]*emailClient{}}
+}
+
+func (a *accountClient) Send(message *models.Message) error {
+	if message == nil {
+		return errors.New("message it's empty")
+	}
+	client, err := a.client(message.AccountID)
+	if err != nil {
+		return err
+	}
+	return client.Send(message)
+}
+
+// SendBatch sends one batch per account. Results keep the order of messages.
+func (a *accountClient) SendBatch(messages []*models.Message) []emailclient.SendResult {
+	results := make([]emailclient.SendResult, len(messages))
+	groups := map[string][]int{}
+	accounts := []string{}
+
+	for i, message := range messages {
+		if message == nil {
+			results[i].Err = errors.New("message it's empty")
+			continue
+		}
+		if _, ok := groups[message.AccountID]; !ok {
+			accounts = append(accounts, message.AccountID)
+		}
+		groups[message.AccountID] = append(groups[message.AccountID], i)
+	}
+
+	for _, account := range accounts {
+		index := groups[account]
+		client, err := a.client(account)
+		if err != nil {
+			for _, i := range index {
+				results[i] = emailclient.SendResult{Target: messages[i].Target, Err: err}
+			}
+			continue
+		}
+
+		group := make([]*models.Message, len(index))
+		for j, i := range index {
+			group[j] = messages[i]
+		}
+		for j, result := range client.SendBatch(group) {
+			results[index[j]] = result
+		}
+	}
+	return results
+}
+
+// client returns the Postmark client of account, reusing one per credentials
+func (a *accountClient) client(account string) (*emailClient, error) {
+	token, sender, err := a.lookup(account)
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		token = os.Getenv("POSTMARK_KEY")
+	}
+	if sender == "" {
+		sender = os.Getenv("PLATFORM_EMAIL")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := [2]string{token, sender}
+	client, ok := a.clients[key]
+	if !ok {
+		client = newEmailClient(a.baseURL, token, sender)
+		a.clients[key] = client
+	}
+	return client, nil
+}
@@ -6,31 +6,39 @@ type EmailTemplateAttachment struct {
 	ContentType string `json:"ContentType"`
 }
 
+// EmailHeader is a custom header added to the outgoing email
+type EmailHeader struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
+
 // EmailWithTemplateRequest payload definition
 type EmailWithTemplateRequest struct {
-	TemplateAlias string                 `json:"TemplateAlias"`
-	TemplateModel map[string]interface{} `json:"TemplateModel"`
-	InlineCSS     bool                   `json:"InlineCss,omitempty"`
-	From          string                 `json:"From"`
-	To            string                 `json:"To"`
-	Cc            string                 `json:"Cc,omitempty"`
-	Bcc           string                 `json:"Bcc,omitempty"`
-	Tag           string                 `json:"Tag,omitempty"`
-	ReplyTo       string                 `json:"ReplyTo,omitempty"`
-	Headers       []struct {
-		Name  string `json:"Name"`
-		Value string `json:"Value"`
-	} `json:"Headers,omitempty"`
-	TrackOpens  *bool                     `json:"TrackOpens,omitempty"`
-	TrackLinks  string                    `json:"TrackLinks,omitempty"`
-	Attachments []EmailTemplateAttachment `json:"Attachments,omitempty"`
-	Metadata    struct {
+	TemplateAlias string                    `json:"TemplateAlias"`
+	TemplateModel map[string]interface{}    `json:"TemplateModel"`
+	InlineCSS     bool                      `json:"InlineCss,omitempty"`
+	From          string                    `json:"From"`
+	To            string                    `json:"To"`
+	Cc            string                    `json:"Cc,omitempty"`
+	Bcc           string                    `json:"Bcc,omitempty"`
+	Tag           string                    `json:"Tag,omitempty"`
+	ReplyTo       string                    `json:"ReplyTo,omitempty"`
+	Headers       []EmailHeader             `json:"Headers,omitempty"`
+	TrackOpens    *bool                     `json:"TrackOpens,omitempty"`
+	TrackLinks    string                    `json:"TrackLinks,omitempty"`
+	Attachments   []EmailTemplateAttachment `json:"Attachments,omitempty"`
+	Metadata      struct {
 		Color    string `json:"color"`
 		ClientID string `json:"client-id"`
 	} `json:"Metadata,omitempty"`
 	MessageStream string `json:"MessageStream,omitempty"`
 }
 
+// BatchWithTemplatesRequest payload definition
+type BatchWithTemplatesRequest struct {
+	Messages []EmailWithTemplateRequest `json:"Messages"`
+}
+
 // EmailWithTemplateResponse payload definition
 type EmailWithTemplateResponse struct {
 	To          string `json:"To"`
@@ -2,24 +2,34 @@ package postmark
 
 import (
 	"errors"
+	"fmt"
 	"github.com/go-resty/resty/v2"
 	"go.uber.org/zap"
 	"os"
+	"sort"
 	"waitlist/lib/emailclient"
 	"waitlist/lib/logger"
 	"waitlist/models"
 )
 
 const (
-	postmarkAPIURL                = "https://api.postmarkapp.com"
+	providerName = "postmark"
+	// APIURL is the base URL of the Postmark API
+	APIURL                        = "https://api.postmarkapp.com"
 	sendEmailWithTemplateEndpoint = "/email/withTemplate/"
+	// sendBatchWithTemplatesEndpoint accepts at most maxBatchSize messages per call
+	sendBatchWithTemplatesEndpoint = "/email/batchWithTemplates"
+	maxBatchSize                   = 500
 )
 
-// Ensure implementation of EmailClient interface
+// Ensure implementation of EmailClient and BatchSender interfaces
 var _ emailclient.EmailClient = (*emailClient)(nil)
+var _ emailclient.BatchSender = (*emailClient)(nil)
 
 type emailClient struct {
 	RESTClient *resty.Client
+	// sender is the From address of messages that don't set their own
+	sender string
 }
 
 // Send generate and send a new email message using postmark API
@@ -29,26 +39,8 @@ func (e *emailClient) Send(message *models.Message) error {
 	if message == nil {
 		return errors.New("message it's empty")
 	}
-	request := EmailWithTemplateRequest{
-		TemplateAlias: message.TemplateID,
-		TemplateModel: map[string]interface{}{
-			"Data": message.DataMap,
-		},
-		From: os.Getenv("PLATFORM_EMAIL"),
-		To:   message.Target,
-	}
-
-	if len(message.Attachments) > 0 {
-		attachments := make([]EmailTemplateAttachment, len(message.Attachments))
-		for i, attachment := range message.Attachments {
-			attachments[i] = EmailTemplateAttachment{
-				Name:        attachment.Name,
-				Content:     attachment.Content,
-				ContentType: attachment.ContentType,
-			}
-		}
-		request.Attachments = attachments
-	}
+	request := buildRequest(message, e.sender)
+	message.Provider = providerName
 
 	// Execute call to postmark API
 	var result EmailWithTemplateResponse
@@ -65,6 +57,7 @@ func (e *emailClient) Send(message *models.Message) error {
 		//return fmt.Errorf("postmark call response error with code: %d, message: %s", errorResponse.ErrorCode,
 		//	errorResponse.Message)
 		logService.Error("Error sending email", zap.String("Message", errorResponse.Message), zap.Int64("Code", int64(errorResponse.ErrorCode)))
+		message.Error = errorResponse.Message
 		return nil
 	}
 
@@ -72,24 +65,140 @@ func (e *emailClient) Send(message *models.Message) error {
 	if result.ErrorCode > 0 {
 		//return fmt.Errorf("postmark call response error with message_id: %s, message_body: %s", result.MessageID, result.Message)
 		logService.Error("Error sending email", zap.String("Message", errorResponse.Message), zap.Int64("Code", int64(errorResponse.ErrorCode)))
+		message.Error = result.Message
 		return nil
 	}
+	message.ProviderMessageID = result.MessageID
 	return nil
 }
 
+// SendBatch sends messages through the postmark batch API in chunks of at most
+// maxBatchSize. Results are returned in the same order as messages.
+func (e *emailClient) SendBatch(messages []*models.Message) []emailclient.SendResult {
+	results := make([]emailclient.SendResult, len(messages))
+
+	for start := 0; start < len(messages); start += maxBatchSize {
+		end := start + maxBatchSize
+		if end > len(messages) {
+			end = len(messages)
+		}
+		e.sendChunk(messages[start:end], results[start:end])
+	}
+	return results
+}
+
+// sendChunk sends a single batch call and fills results, which has the same length as messages
+func (e *emailClient) sendChunk(messages []*models.Message, results []emailclient.SendResult) {
+	request := BatchWithTemplatesRequest{Messages: make([]EmailWithTemplateRequest, 0, len(messages))}
+	index := make([]int, 0, len(messages))
+	for i, message := range messages {
+		if message == nil {
+			results[i].Err = errors.New("message it's empty")
+			continue
+		}
+		results[i].Target = message.Target
+		message.Provider = providerName
+		request.Messages = append(request.Messages, buildRequest(message, e.sender))
+		index = append(index, i)
+	}
+	if len(index) == 0 {
+		return
+	}
+
+	var result []EmailWithTemplateResponse
+	var errorResponse ErrorResponse
+	response, err := e.RESTClient.R().
+		SetBody(request).
+		SetResult(&result).
+		SetError(&errorResponse).
+		Post(sendBatchWithTemplatesEndpoint)
+	if err == nil && response.IsError() {
+		err = fmt.Errorf("postmark call response error with code: %d, message: %s", errorResponse.ErrorCode, errorResponse.Message)
+	}
+	if err == nil && len(result) != len(index) {
+		err = fmt.Errorf("postmark returned %d results for %d messages", len(result), len(index))
+	}
+	if err != nil {
+		for _, i := range index {
+			results[i].Err = err
+		}
+		return
+	}
+
+	// Check https://postmarkapp.com/developer/api/overview#error-codes for error codes
+	for j, i := range index {
+		results[i].MessageID = result[j].MessageID
+		messages[i].ProviderMessageID = result[j].MessageID
+		if result[j].ErrorCode > 0 {
+			results[i].Err = fmt.Errorf("postmark error code: %d, message: %s", result[j].ErrorCode, result[j].Message)
+		}
+	}
+}
+
+// buildRequest maps a message to the postmark template payload, sending from
+// sender unless the message names its own
+func buildRequest(message *models.Message, sender string) EmailWithTemplateRequest {
+	request := EmailWithTemplateRequest{
+		TemplateAlias: message.TemplateID,
+		TemplateModel: map[string]interface{}{
+			"Data": message.DataMap,
+		},
+		From: message.Sender,
+		To:   message.Target,
+	}
+	if request.From == "" {
+		request.From = sender
+	}
+
+	if len(message.Headers) > 0 {
+		names := make([]string, 0, len(message.Headers))
+		for name := range message.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			request.Headers = append(request.Headers, EmailHeader{Name: name, Value: message.Headers[name]})
+		}
+	}
+
+	if len(message.Attachments) > 0 {
+		attachments := make([]EmailTemplateAttachment, len(message.Attachments))
+		for i, attachment := range message.Attachments {
+			attachments[i] = EmailTemplateAttachment{
+				Name:        attachment.Name,
+				Content:     attachment.Content,
+				ContentType: attachment.ContentType,
+			}
+		}
+		request.Attachments = attachments
+	}
+	return request
+}
+
 // New return a new instance of a Postmark definition for EmailClient interface
 func New() emailclient.EmailClient {
+	return NewWithToken(APIURL, os.Getenv("POSTMARK_KEY"), os.Getenv("PLATFORM_EMAIL"))
+}
+
+// NewWithToken returns a Postmark EmailClient calling the API at baseURL with
+// the server token, sending from sender by default
+func NewWithToken(baseURL string, token string, sender string) emailclient.EmailClient {
+	return newEmailClient(baseURL, token, sender)
+}
+
+func newEmailClient(baseURL string, token string, sender string) *emailClient {
 	// Build REST client
 	restClient := resty.New()
-	restClient.SetBaseURL(postmarkAPIURL)
+	restClient.SetBaseURL(baseURL)
 	restClient.SetHeader("Content-Type", "application/json")
 	restClient.SetHeader("Accept", "application/json")
-	restClient.SetHeader("X-Postmark-Server-Token", os.Getenv("POSTMARK_KEY"))
+	restClient.SetHeader("X-Postmark-Server-Token", token)
 	restClient.SetDebug(true)
 
 	// Define service attributes
 	emailClient := emailClient{
 		RESTClient: restClient,
+		sender:     sender,
 	}
 
 	return &emailClient
@@ -0,0 +1,132 @@
+package postmark
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"waitlist/models"
+)
+
+// batchServer answers batch calls with one result per message, failing the
+// messages whose address starts with "bad"
+func batchServer(t *testing.T, sizes *[]int) *httptest.Server {
+	var mu sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != sendBatchWithTemplatesEndpoint {
+			t.Errorf("unexpected call %s %s", r.Method, r.URL.Path)
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if token := r.Header.Get("X-Postmark-Server-Token"); token != "token" {
+			t.Errorf("server token = %q, want %q", token, "token")
+		}
+
+		request := BatchWithTemplatesRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("decoding batch: %v", err)
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		*sizes = append(*sizes, len(request.Messages))
+		mu.Unlock()
+
+		results := make([]EmailWithTemplateResponse, len(request.Messages))
+		for i, message := range request.Messages {
+			results[i] = EmailWithTemplateResponse{To: message.To, MessageID: "id-" + message.To}
+			if strings.HasPrefix(message.To, "bad") {
+				results[i] = EmailWithTemplateResponse{To: message.To, ErrorCode: 300, Message: "Invalid email request"}
+			}
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(results)
+	}))
+}
+
+func messages(n int) []*models.Message {
+	list := make([]*models.Message, n)
+	for i := range list {
+		list[i] = &models.Message{Target: fmt.Sprintf("user%d@example.com", i), TemplateID: "welcome"}
+	}
+	return list
+}
+
+func TestSendBatchChunks(t *testing.T) {
+	sizes := []int{}
+	server := batchServer(t, &sizes)
+	defer server.Close()
+
+	client := newEmailClient(server.URL, "token", "sender@example.com")
+	results := client.SendBatch(messages(1001))
+
+	if len(sizes) != 3 || sizes[0] != maxBatchSize || sizes[1] != maxBatchSize || sizes[2] != 1 {
+		t.Fatalf("batch sizes = %v, want [500 500 1]", sizes)
+	}
+	if len(results) != 1001 {
+		t.Fatalf("got %d results, want 1001", len(results))
+	}
+	for i, result := range results {
+		if result.Err != nil {
+			t.Errorf("result %d: unexpected error %v", i, result.Err)
+		}
+	}
+}
+
+func TestSendBatchMapsResults(t *testing.T) {
+	sizes := []int{}
+	server := batchServer(t, &sizes)
+	defer server.Close()
+
+	batch := []*models.Message{
+		{Target: "first@example.com"},
+		nil,
+		{Target: "bad@example.com"},
+		{Target: "last@example.com"},
+	}
+	client := newEmailClient(server.URL, "token", "sender@example.com")
+	results := client.SendBatch(batch)
+
+	if len(sizes) != 1 || sizes[0] != 3 {
+		t.Fatalf("batch sizes = %v, want [3]", sizes)
+	}
+	if results[0].Target != "first@example.com" || results[0].MessageID != "id-first@example.com" || results[0].Err != nil {
+		t.Errorf("result 0 = %+v", results[0])
+	}
+	if results[1].Err == nil {
+		t.Errorf("nil message: expected an error")
+	}
+	if results[2].Target != "bad@example.com" || results[2].Err == nil {
+		t.Errorf("result 2 = %+v, want an error", results[2])
+	}
+	if results[3].Target != "last@example.com" || results[3].MessageID != "id-last@example.com" || results[3].Err != nil {
+		t.Errorf("result 3 = %+v", results[3])
+	}
+	if batch[3].ProviderMessageID != "id-last@example.com" || batch[3].Provider != providerName {
+		t.Errorf("message 3 = %+v, want the provider message id set", batch[3])
+	}
+}
+
+func TestSendBatchErrorResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(rw).Encode(ErrorResponse{ErrorCode: 10, Message: "Bad or missing API token"})
+	}))
+	defer server.Close()
+
+	client := newEmailClient(server.URL, "token", "sender@example.com")
+	results := client.SendBatch(messages(3))
+
+	for i, result := range results {
+		if result.Err == nil || !strings.Contains(result.Err.Error(), "Bad or missing API token") {
+			t.Errorf("result %d: error = %v, want the batch error", i, result.Err)
+		}
+		if result.MessageID != "" {
+			t.Errorf("result %d: message id = %q, want none", i, result.MessageID)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package emailclient
+
+import (
+	"context"
+	"errors"
+	"waitlist/models"
+)
+
+// ErrSuppressed is returned instead of sending to a suppressed recipient
+var ErrSuppressed = errors.New("recipient is on the suppression list")
+
+// SuppressionChecker reports whether an address must not be contacted by an account
+type SuppressionChecker interface {
+	IsSuppressed(ctx context.Context, account string, address string) (bool, error)
+}
+
+type suppressedClient struct {
+	next    EmailClient
+	checker SuppressionChecker
+}
+
+// WithSuppression wraps client so that every send first consults checker
+func WithSuppression(client EmailClient, checker SuppressionChecker) EmailClient {
+	return &suppressedClient{next: client, checker: checker}
+}
+
+// Send skips suppressed recipients with ErrSuppressed before reaching the provider
+func (s *suppressedClient) Send(message *models.Message) error {
+	if message == nil {
+		return errors.New("message it's empty")
+	}
+	suppressed, err := s.checker.IsSuppressed(context.Background(), message.AccountID, message.Target)
+	if err != nil {
+		return err
+	}
+	if suppressed {
+		return ErrSuppressed
+	}
+	return s.next.Send(message)
+}
+
+// SendBatch marks suppressed recipients with ErrSuppressed and sends the rest as one batch
+func (s *suppressedClient) SendBatch(messages []*models.Message) []SendResult {
+	results := make([]SendResult, len(messages))
+	pending := make([]*models.Message, 0, len(messages))
+	index := make([]int, 0, len(messages))
+
+	for i, message := range messages {
+		if message == nil {
+			results[i].Err = errors.New("message it's empty")
+			continue
+		}
+		results[i].Target = message.Target
+
+		suppressed, err := s.checker.IsSuppressed(context.Background(), message.AccountID, message.Target)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		if suppressed {
+			results[i].Err = ErrSuppressed
+			continue
+		}
+		pending = append(pending, message)
+		index = append(index, i)
+	}
+
+	if len(pending) > 0 {
+		for i, result := range SendBatch(s.next, pending) {
+			results[index[i]] = result
+		}
+	}
+	return results
+}
@@ -0,0 +1,57 @@
+package emailclient
+
+import (
+	"errors"
+	"waitlist/models"
+)
+
+// UnsubscribeLinker builds the signed unsubscribe URL for an address on a waitlist
+type UnsubscribeLinker interface {
+	UnsubscribeURL(address, waitlist string) string
+}
+
+type unsubscribeClient struct {
+	next   EmailClient
+	linker UnsubscribeLinker
+}
+
+// WithUnsubscribe wraps client so that every email carries an unsubscribe link
+// and the RFC 8058 one-click List-Unsubscribe headers
+func WithUnsubscribe(client EmailClient, linker UnsubscribeLinker) EmailClient {
+	return &unsubscribeClient{next: client, linker: linker}
+}
+
+// Send adds the unsubscribe headers and template data before delegating
+func (u *unsubscribeClient) Send(message *models.Message) error {
+	if message == nil {
+		return errors.New("message it's empty")
+	}
+	addUnsubscribe(message, u.linker.UnsubscribeURL(message.Target, message.Waitlist))
+	return u.next.Send(message)
+}
+
+// SendBatch adds the unsubscribe headers and template data to every message before delegating
+func (u *unsubscribeClient) SendBatch(messages []*models.Message) []SendResult {
+	for _, message := range messages {
+		if message != nil {
+			addUnsubscribe(message, u.linker.UnsubscribeURL(message.Target, message.Waitlist))
+		}
+	}
+	return SendBatch(u.next, messages)
+}
+
+// addUnsubscribe sets the headers and template data of the link, which is
+// signed and never expires, so it's kept out of the message log as a secret
+func addUnsubscribe(message *models.Message, link string) {
+	message.Secrets = append(message.Secrets, link)
+	if message.Headers == nil {
+		message.Headers = map[string]string{}
+	}
+	message.Headers["List-Unsubscribe"] = "<" + link + ">"
+	message.Headers["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
+
+	if message.DataMap == nil {
+		message.DataMap = map[string]string{}
+	}
+	message.DataMap["UnsubscribeURL"] = link
+}
@@ -0,0 +1,262 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+	"waitlist/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// backlogSize is how many past events are kept for Last-Event-ID resume
+	backlogSize = 1000
+	// subscriberBuffer is how far a subscriber can fall behind before it is dropped
+	subscriberBuffer = 64
+	// changeStreamUnsupported is the server error for $changeStream outside replica sets
+	changeStreamUnsupported = 40573
+	watchRetryInterval      = 10 * time.Second
+	// eventSequence is the counters document numbering emitted events
+	eventSequence = "events"
+)
+
+// Feed fans waitlist events out to subscribers. Events come from a change
+// stream on the waitlist collection when the deployment supports one, so
+// every replica sees every change, and from Emit otherwise.
+//
+// Event ids hold a time in seconds in their high 32 bits so they order events
+// across replicas and restarts: change stream events use their cluster time,
+// which every replica sees alike, and emitted events a persisted sequence.
+type Feed struct {
+	counters *mongo.Collection
+
+	mu          sync.Mutex
+	nextID      uint32
+	backlog     []models.Event
+	subscribers map[chan models.Event]bool
+	// horizon is the newest event this feed can't replay, events after it are in the backlog
+	horizon uint64
+
+	// streaming is set while a change stream delivers events
+	streaming atomic.Bool
+}
+
+// New returns an empty Feed numbering emitted events from the sequence kept
+// in counters. Without counters the sequence only lives as long as the process.
+func New(counters *mongo.Collection) *Feed {
+	return &Feed{
+		counters:    counters,
+		subscribers: map[chan models.Event]bool{},
+		// events from before this process started can't be replayed
+		horizon: eventID(uint32(time.Now().Unix()), 0),
+	}
+}
+
+// Emit publishes an event raised by a handler, unless a change stream is
+// already delivering events
+func (f *Feed) Emit(event models.Event) {
+	if f.streaming.Load() {
+		return
+	}
+	event.ID = f.sequence()
+	f.publish(event)
+}
+
+// Subscribe registers a subscriber. Events after lastID still in the backlog
+// are returned for replay. When lastID is too old to resume from, reset is
+// set and the backlog is empty: the subscriber may have missed events.
+// cancel must be called once the subscriber is done. The channel is closed
+// when the subscriber falls too far behind.
+func (f *Feed) Subscribe(lastID uint64) (backlog []models.Event, reset bool, events <-chan models.Event, cancel func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if lastID > 0 && lastID < f.horizon {
+		reset = true
+	} else if lastID > 0 {
+		for _, event := range f.backlog {
+			if event.ID > lastID {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+
+	ch := make(chan models.Event, subscriberBuffer)
+	f.subscribers[ch] = true
+	cancel = func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.subscribers[ch] {
+			delete(f.subscribers, ch)
+			close(ch)
+		}
+	}
+	return backlog, reset, ch, cancel
+}
+
+// sequence returns the id of the next emitted event, from the persisted
+// sequence so replicas never hand out the same one
+func (f *Feed) sequence() uint64 {
+	now := uint32(time.Now().Unix())
+	if f.counters != nil {
+		counter := struct {
+			Seq int64 `bson:"seq"`
+		}{}
+		update := bson.M{"$inc": bson.M{"seq": 1}}
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+		err := f.counters.FindOneAndUpdate(context.Background(), bson.M{"_id": eventSequence}, update, opts).Decode(&counter)
+		if err == nil {
+			return eventID(now, uint32(counter.Seq))
+		}
+		log.Println("unable to number event, using the process sequence:", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	return eventID(now, f.nextID)
+}
+
+// eventID packs seconds and a counter, unique within that second, into an event id
+func eventID(seconds uint32, counter uint32) uint64 {
+	return uint64(seconds)<<32 | uint64(counter)
+}
+
+func (f *Feed) publish(event models.Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if event.Time == 0 {
+		event.Time = time.Now().Unix()
+	}
+	event.Entry = event.Entry.Published()
+	f.backlog = append(f.backlog, event)
+	if len(f.backlog) > backlogSize {
+		dropped := f.backlog[:len(f.backlog)-backlogSize]
+		for _, event := range dropped {
+			if event.ID > f.horizon {
+				f.horizon = event.ID
+			}
+		}
+		f.backlog = f.backlog[len(f.backlog)-backlogSize:]
+	}
+
+	for ch := range f.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// the subscriber resumes from the backlog when it reconnects
+			delete(f.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Watch feeds events from a change stream on collection until ctx is done.
+// It returns straight away when the deployment has no change streams, leaving
+// Emit as the only source.
+func (f *Feed) Watch(ctx context.Context, collection *mongo.Collection) {
+	// entries removed outright, rather than soft deleted, are only visible to
+	// the stream with pre-images (MongoDB 6.0+)
+	command := bson.D{{Key: "collMod", Value: collection.Name()}, {Key: "changeStreamPreAndPostImages", Value: bson.M{"enabled": true}}}
+	if err := collection.Database().RunCommand(ctx, command).Err(); err != nil {
+		log.Println("change stream pre-images unavailable:", err)
+	}
+
+	var resumeToken bson.Raw
+	for {
+		err := f.watch(ctx, collection, &resumeToken)
+		f.streaming.Store(false)
+		if ctx.Err() != nil {
+			return
+		}
+
+		var commandErr mongo.CommandError
+		if errors.As(err, &commandErr) && commandErr.Code == changeStreamUnsupported {
+			log.Println("change streams unavailable, using in-process events")
+			return
+		}
+		log.Println("change stream interrupted:", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+func (f *Feed) watch(ctx context.Context, collection *mongo.Collection, resumeToken *bson.Raw) error {
+	pipeline := bson.A{bson.M{"$match": bson.M{"$or": bson.A{
+		bson.M{"operationType": bson.M{"$in": bson.A{"insert", "delete"}}},
+		bson.M{"operationType": "update", "updateDescription.updatedFields.confirmed_at": bson.M{"$exists": true}},
+		bson.M{"operationType": "update", "updateDescription.updatedFields.deleted_at": bson.M{"$exists": true}},
+	}}}}
+	opts := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup).
+		SetFullDocumentBeforeChange(options.WhenAvailable)
+	if *resumeToken != nil {
+		opts.SetResumeAfter(*resumeToken)
+	}
+
+	stream, err := collection.Watch(ctx, pipeline, opts)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+	f.streaming.Store(true)
+
+	for stream.Next(ctx) {
+		*resumeToken = stream.ResumeToken()
+
+		change := struct {
+			OperationType     string `bson:"operationType"`
+			UpdateDescription struct {
+				UpdatedFields bson.M `bson:"updatedFields"`
+			} `bson:"updateDescription"`
+			ClusterTime              primitive.Timestamp   `bson:"clusterTime"`
+			FullDocument             *models.WaitlistEntry `bson:"fullDocument"`
+			FullDocumentBeforeChange *models.WaitlistEntry `bson:"fullDocumentBeforeChange"`
+		}{}
+		if err := stream.Decode(&change); err != nil {
+			log.Println("unable to decode change event:", err)
+			continue
+		}
+
+		// the cluster time orders the change and is the same on every replica
+		event := models.Event{ID: eventID(change.ClusterTime.T, change.ClusterTime.I), Entry: change.FullDocument}
+		switch change.OperationType {
+		case "insert":
+			event.Type = models.ENTRY_CREATED_EVENT_TYPE
+		case "update":
+			// a soft delete sets deleted_at, anything else that got through is a confirmation
+			event.Type = models.ENTRY_CONFIRMED_EVENT_TYPE
+			if _, ok := change.UpdateDescription.UpdatedFields["deleted_at"]; ok {
+				event.Type = models.ENTRY_DELETED_EVENT_TYPE
+			}
+		case "delete":
+			event.Type = models.ENTRY_DELETED_EVENT_TYPE
+			event.Entry = change.FullDocumentBeforeChange
+			if event.Entry != nil && event.Entry.DeletedAt > 0 {
+				// purged after a soft delete, which was announced already
+				continue
+			}
+		}
+		if event.Entry == nil {
+			// without the document there is no tenant to deliver the event to
+			continue
+		}
+		event.TenantID = event.Entry.TenantID
+		event.Waitlist = event.Entry.Waitlist
+		event.Email = event.Entry.Email
+		f.publish(event)
+	}
+	return stream.Err()
+}
@@ -0,0 +1,82 @@
+package linksigner
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+)
+
+// Signer produces and verifies HMAC signed links bound to an email address
+type Signer struct {
+	secret  []byte
+	baseURL string
+}
+
+// New returns a Signer using secret for signatures and baseURL as the public address of the API
+func New(secret, baseURL string) *Signer {
+	return &Signer{
+		secret:  []byte(secret),
+		baseURL: strings.TrimRight(baseURL, "/"),
+	}
+}
+
+// Sign returns the token for email, scoped to purpose so tokens cannot be reused across links
+func (s *Signer) Sign(purpose, email string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(purpose + ":" + strings.ToLower(email)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether token was produced by Sign for purpose and email
+func (s *Signer) Verify(purpose, email, token string) bool {
+	expected := s.Sign(purpose, email)
+	return hmac.Equal([]byte(expected), []byte(token))
+}
+
+// URL builds an absolute signed link to path for email on waitlist. Only the
+// email is signed: the waitlist merely picks which of that person's entries the
+// link acts on.
+func (s *Signer) URL(path, purpose, email, waitlist string) string {
+	query := url.Values{}
+	query.Set("email", email)
+	query.Set("token", s.Sign(purpose, email))
+	if waitlist != "" {
+		query.Set("waitlist", waitlist)
+	}
+	return s.baseURL + path + "?" + query.Encode()
+}
+
+const (
+	// UnsubscribePurpose scopes tokens used by unsubscribe links
+	UnsubscribePurpose = "unsubscribe"
+	unsubscribePath    = "/api/unsubscribe"
+
+	// ConfirmPurpose scopes tokens used by email confirmation links
+	ConfirmPurpose = "confirm"
+	confirmPath    = "/api/confirm"
+
+	privacyPath = "/api/privacy"
+)
+
+// UnsubscribeURL returns the signed one-click unsubscribe link for address on waitlist
+func (s *Signer) UnsubscribeURL(address, waitlist string) string {
+	return s.URL(unsubscribePath, UnsubscribePurpose, address, waitlist)
+}
+
+// ConfirmURL returns the signed link confirming address belongs to the person who signed up to waitlist
+func (s *Signer) ConfirmURL(address, waitlist string) string {
+	return s.URL(confirmPath, ConfirmPurpose, address, waitlist)
+}
+
+// PrivacyURL returns the link acting on a data subject request. Its token is a
+// random secret stored hashed with the request rather than a signature, so the
+// link expires and stops working once the request is done.
+func (s *Signer) PrivacyURL(action, token string) string {
+	path := privacyPath
+	if action != "" {
+		path += "/" + action
+	}
+	return s.baseURL + path + "?" + url.Values{"token": {token}}.Encode()
+}
@@ -0,0 +1,186 @@
+package messagelog
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"waitlist/lib/emailclient"
+	"waitlist/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// redacted replaces secrets in stored messages
+const redacted = "[redacted]"
+
+// ErrNotFound is returned when no message has the requested id
+var ErrNotFound = errors.New("message not found")
+
+// Store persists every message sent by the service in the messages collection
+type Store struct {
+	collection *mongo.Collection
+}
+
+// Query filters a search of the log. Zero values are ignored, except Account
+// which always restricts the search to one tenant.
+type Query struct {
+	Account   string
+	Waitlist  string
+	Recipient string
+	Type      models.MessageType
+	Status    models.MessageStatus
+	From      int64
+	To        int64
+	Limit     int64
+	Skip      int64
+}
+
+// New returns a Store backed by the messages collection of db
+func New(db *mongo.Database) *Store {
+	collection := db.Collection("messages")
+
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "target", Value: 1}, {Key: "ts", Value: -1}}},
+		{Keys: bson.D{{Key: "ts", Value: -1}}},
+		{Keys: bson.D{{Key: "account_id", Value: 1}, {Key: "ts", Value: -1}}},
+	}
+	if _, err := collection.Indexes().CreateMany(context.Background(), indexes); err != nil {
+		log.Println("unable to create messages indexes:", err)
+	}
+
+	return &Store{collection: collection}
+}
+
+// Record stores the outcome of sending message. sendErr is the error returned by the client.
+func (s *Store) Record(message *models.Message, sendErr error) error {
+	now := time.Now().Unix()
+	if message.ID == "" {
+		message.ID = primitive.NewObjectID().Hex()
+	}
+	if message.Ts == 0 {
+		message.Ts = now
+	}
+
+	switch {
+	case sendErr == emailclient.ErrSuppressed:
+		message.Status = models.SKIPPED_MESSAGE_STATUS
+		message.Error = sendErr.Error()
+	case sendErr != nil:
+		message.Status = models.FAILED_MESSAGE_STATUS
+		message.Error = sendErr.Error()
+	case message.Error != "":
+		// the provider rejected the message without failing the call
+		message.Status = models.FAILED_MESSAGE_STATUS
+	default:
+		message.Status = models.SENT_MESSAGE_STATUS
+		message.SentAt = now
+	}
+
+	_, err := s.collection.InsertOne(context.Background(), redact(message))
+	return err
+}
+
+// redact returns a copy of message with its secrets replaced, in the template
+// data, the headers and the body, so codes and signed links can't be read
+// back from the log
+func redact(message *models.Message) *models.Message {
+	if len(message.Secrets) == 0 {
+		return message
+	}
+	stored := *message
+	stored.DataMap = redactMap(message.DataMap, message.Secrets)
+	stored.Headers = redactMap(message.Headers, message.Secrets)
+	stored.Body = redactString(message.Body, message.Secrets)
+	return &stored
+}
+
+func redactMap(values map[string]string, secrets []string) map[string]string {
+	if values == nil {
+		return nil
+	}
+	redactedValues := make(map[string]string, len(values))
+	for key, value := range values {
+		redactedValues[key] = redactString(value, secrets)
+	}
+	return redactedValues
+}
+
+func redactString(value string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			value = strings.ReplaceAll(value, secret, redacted)
+		}
+	}
+	return value
+}
+
+// Search returns the messages matching query, newest first
+func (s *Store) Search(ctx context.Context, query Query) ([]models.Message, error) {
+	filter := bson.M{"account_id": query.Account}
+	if query.Waitlist != "" {
+		filter["waitlist"] = query.Waitlist
+	}
+	if query.Recipient != "" {
+		filter["target"] = query.Recipient
+	}
+	if query.Type != "" {
+		filter["type"] = query.Type
+	}
+	if query.Status != "" {
+		filter["status"] = query.Status
+	}
+	ts := bson.M{}
+	if query.From > 0 {
+		ts["$gte"] = query.From
+	}
+	if query.To > 0 {
+		ts["$lt"] = query.To
+	}
+	if len(ts) > 0 {
+		filter["ts"] = ts
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "ts", Value: -1}}).SetSkip(query.Skip)
+	if query.Limit > 0 {
+		opts.SetLimit(query.Limit)
+	}
+
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	messages := []models.Message{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// Get returns the message of account with the given id
+func (s *Store) Get(ctx context.Context, account string, id string) (*models.Message, error) {
+	message := models.Message{}
+	err := s.collection.FindOne(ctx, bson.M{"account_id": account, "id": id}).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// Erase deletes the messages of account sent to any of targets and returns how many were removed
+func (s *Store) Erase(ctx context.Context, account string, targets []string) (int64, error) {
+	result, err := s.collection.DeleteMany(ctx, bson.M{"account_id": account, "target": bson.M{"$in": targets}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
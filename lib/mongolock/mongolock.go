@@ -0,0 +1,68 @@
+package mongolock
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Lock is a lease stored in the locks collection. Only one replica holds a
+// given lock at a time; a lease that is not renewed expires after its ttl.
+type Lock struct {
+	collection *mongo.Collection
+	name       string
+	owner      string
+	ttl        time.Duration
+}
+
+// New returns the lock called name. Each Lock value is a distinct owner.
+func New(db *mongo.Database, name string, ttl time.Duration) *Lock {
+	host, _ := os.Hostname()
+	return &Lock{
+		collection: db.Collection("locks"),
+		name:       name,
+		owner:      fmt.Sprintf("%s-%d-%s", host, os.Getpid(), primitive.NewObjectID().Hex()),
+		ttl:        ttl,
+	}
+}
+
+// Owner identifies this holder of the lock
+func (l *Lock) Owner() string {
+	return l.owner
+}
+
+// Acquire takes or renews the lease and reports whether this owner holds it
+func (l *Lock) Acquire(ctx context.Context) (bool, error) {
+	now := time.Now()
+	filter := bson.M{
+		"_id": l.name,
+		"$or": bson.A{
+			bson.M{"owner": l.owner},
+			bson.M{"expires_at": bson.M{"$lt": now.Unix()}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"owner": l.owner, "expires_at": now.Add(l.ttl).Unix()}}
+
+	// when someone else holds the lease the filter misses and the upsert
+	// collides with their document
+	_, err := l.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Release gives the lease up if this owner holds it
+func (l *Lock) Release(ctx context.Context) error {
+	_, err := l.collection.DeleteOne(ctx, bson.M{"_id": l.name, "owner": l.owner})
+	return err
+}
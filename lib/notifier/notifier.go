@@ -0,0 +1,115 @@
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"waitlist/lib/emailclient"
+	"waitlist/models"
+)
+
+// ErrNoChannel is returned for messages whose type has no registered client
+var ErrNoChannel = errors.New("no client registered for message type")
+
+// ErrRejected is returned for messages the provider refused without failing
+// the call, which clients report by setting message.Error
+var ErrRejected = errors.New("message rejected by provider")
+
+// Sender delivers a message over a single channel. Both EmailClient and SMSClient satisfy it.
+type Sender interface {
+	Send(message *models.Message) error
+}
+
+// Recorder keeps a log of every message sent and its outcome
+type Recorder interface {
+	Record(message *models.Message, sendErr error) error
+}
+
+// Notifier routes a message to the client registered for its Type
+type Notifier struct {
+	senders  map[models.MessageType]Sender
+	recorder Recorder
+}
+
+// New returns a Notifier without any channel. recorder may be nil.
+func New(recorder Recorder) *Notifier {
+	return &Notifier{
+		senders:  map[models.MessageType]Sender{},
+		recorder: recorder,
+	}
+}
+
+// Register sets the client used for messages of type messageType
+func (n *Notifier) Register(messageType models.MessageType, sender Sender) *Notifier {
+	n.senders[messageType] = sender
+	return n
+}
+
+// Supports reports whether a client is registered for messageType
+func (n *Notifier) Supports(messageType models.MessageType) bool {
+	_, ok := n.senders[messageType]
+	return ok
+}
+
+// Send delivers message through the client registered for its Type. A
+// rejection reported in message.Error is returned as ErrRejected.
+func (n *Notifier) Send(message *models.Message) error {
+	if message == nil {
+		return errors.New("message it's empty")
+	}
+	sender, ok := n.senders[message.Type]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoChannel, message.Type)
+	}
+
+	message.Error = ""
+	err := sender.Send(message)
+	if err == nil && message.Error != "" {
+		err = fmt.Errorf("%w: %s", ErrRejected, message.Error)
+	}
+	n.record(message, err)
+	return err
+}
+
+// SendBatch delivers messages grouped by Type, in one call for clients that support it.
+// Results are returned in the same order as messages.
+func (n *Notifier) SendBatch(messages []*models.Message) []emailclient.SendResult {
+	results := make([]emailclient.SendResult, len(messages))
+	groups := map[models.MessageType][]int{}
+	for i, message := range messages {
+		if message == nil {
+			results[i].Err = errors.New("message it's empty")
+			continue
+		}
+		groups[message.Type] = append(groups[message.Type], i)
+	}
+
+	for messageType, index := range groups {
+		sender, ok := n.senders[messageType]
+		if !ok {
+			for _, i := range index {
+				results[i] = emailclient.SendResult{Target: messages[i].Target, Err: fmt.Errorf("%w: %s", ErrNoChannel, messageType)}
+			}
+			continue
+		}
+
+		group := make([]*models.Message, len(index))
+		for j, i := range index {
+			group[j] = messages[i]
+		}
+		for j, result := range emailclient.SendBatch(sender, group) {
+			results[index[j]] = result
+			n.record(group[j], result.Err)
+		}
+	}
+	return results
+}
+
+func (n *Notifier) record(message *models.Message, sendErr error) {
+	if n.recorder == nil {
+		return
+	}
+	if err := n.recorder.Record(message, sendErr); err != nil {
+		log.Println("unable to record message:", err)
+	}
+}
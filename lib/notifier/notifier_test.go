@@ -0,0 +1,81 @@
+package notifier
+
+import (
+	"errors"
+	"testing"
+	"waitlist/models"
+)
+
+// recordingSender keeps the messages it was asked to send
+type recordingSender struct {
+	sent []*models.Message
+}
+
+func (s *recordingSender) Send(message *models.Message) error {
+	s.sent = append(s.sent, message)
+	return nil
+}
+
+func TestSendRoutesByType(t *testing.T) {
+	email, sms := &recordingSender{}, &recordingSender{}
+	n := New(nil).
+		Register(models.EMAIL_MESSAGE_TYPE, email).
+		Register(models.SMS_MESSAGE_TYPE, sms)
+
+	emailMessage := &models.Message{Type: models.EMAIL_MESSAGE_TYPE, Target: "jane@example.com"}
+	smsMessage := &models.Message{Type: models.SMS_MESSAGE_TYPE, Target: "+15550100"}
+	if err := n.Send(emailMessage); err != nil {
+		t.Fatalf("Send email: %v", err)
+	}
+	if err := n.Send(smsMessage); err != nil {
+		t.Fatalf("Send sms: %v", err)
+	}
+
+	if len(email.sent) != 1 || email.sent[0] != emailMessage {
+		t.Errorf("email client got %v, want only the email", email.sent)
+	}
+	if len(sms.sent) != 1 || sms.sent[0] != smsMessage {
+		t.Errorf("sms client got %v, want only the sms", sms.sent)
+	}
+}
+
+func TestSendUnregisteredType(t *testing.T) {
+	email := &recordingSender{}
+	n := New(nil).Register(models.EMAIL_MESSAGE_TYPE, email)
+
+	err := n.Send(&models.Message{Type: models.SMS_MESSAGE_TYPE, Target: "+15550100"})
+	if !errors.Is(err, ErrNoChannel) {
+		t.Fatalf("Send = %v, want ErrNoChannel", err)
+	}
+	if len(email.sent) != 0 {
+		t.Errorf("email client got %v, want nothing", email.sent)
+	}
+
+	results := n.SendBatch([]*models.Message{
+		{Type: models.EMAIL_MESSAGE_TYPE, Target: "jane@example.com"},
+		{Type: models.PUSH_MESSAGE_TYPE, Target: "device"},
+	})
+	if results[0].Err != nil {
+		t.Errorf("email result: unexpected error %v", results[0].Err)
+	}
+	if !errors.Is(results[1].Err, ErrNoChannel) {
+		t.Errorf("push result = %v, want ErrNoChannel", results[1].Err)
+	}
+}
+
+// rejectingSender reports a provider rejection the way the postmark client does
+type rejectingSender struct{}
+
+func (rejectingSender) Send(message *models.Message) error {
+	message.Error = "Inactive recipient"
+	return nil
+}
+
+func TestSendReturnsRejection(t *testing.T) {
+	n := New(nil).Register(models.EMAIL_MESSAGE_TYPE, rejectingSender{})
+
+	err := n.Send(&models.Message{Type: models.EMAIL_MESSAGE_TYPE, Target: "jane@example.com"})
+	if !errors.Is(err, ErrRejected) {
+		t.Fatalf("Send = %v, want ErrRejected", err)
+	}
+}
@@ -0,0 +1,79 @@
+package slack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"waitlist/lib/webhooks"
+)
+
+// Message is the payload of an incoming webhook. Slack, Mattermost and
+// Rocket.Chat accept it as is. Text is the fallback shown in notifications.
+type Message struct {
+	Text   string  `json:"text"`
+	Blocks []Block `json:"blocks,omitempty"`
+}
+
+// Block is a layout block of a message
+type Block struct {
+	Type string `json:"type"`
+	Text *Text  `json:"text,omitempty"`
+}
+
+// Text is a text object of a block
+type Text struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Section returns a section block rendering markdown
+func Section(markdown string) Block {
+	return Block{Type: "section", Text: &Text{Type: "mrkdwn", Text: markdown}}
+}
+
+// Header returns a header block with plain text
+func Header(text string) Block {
+	return Block{Type: "header", Text: &Text{Type: "plain_text", Text: text}}
+}
+
+// Escape escapes the control characters of Slack markdown in user provided text
+func Escape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// Client posts messages to incoming webhook URLs
+type Client struct {
+	client *http.Client
+}
+
+// New returns a Client using client, or a client with a 10 second timeout
+// that only connects to public addresses when nil
+func New(client *http.Client) *Client {
+	if client == nil {
+		client = webhooks.PublicClient(10 * time.Second)
+	}
+	return &Client{client: client}
+}
+
+// Post sends message to url and returns an error unless the receiver answered
+// 2xx. Response bodies are left out of errors, they are shown to admins.
+func (c *Client) Post(url string, message Message) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.client.Post(url, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %d", response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package httpsms
+
+// SendSMSRequest payload definition
+type SendSMSRequest struct {
+	To   string `json:"to"`
+	From string `json:"from"`
+	Body string `json:"body"`
+}
+
+// SendSMSResponse payload definition
+type SendSMSResponse struct {
+	MessageID string `json:"message_id"`
+	Status    string `json:"status"`
+}
+
+// ErrorResponse payload definition
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,78 @@
+package httpsms
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"waitlist/lib/smsclient"
+	"waitlist/models"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const (
+	providerName    = "httpsms"
+	sendSMSEndpoint = "/messages"
+)
+
+// Ensure implementation of SMSClient interface
+var _ smsclient.SMSClient = (*smsClient)(nil)
+
+type smsClient struct {
+	RESTClient *resty.Client
+	sender     string
+}
+
+// Send delivers message.Body to message.Target, which must be an E.164 number
+func (s *smsClient) Send(message *models.Message) error {
+	if message == nil {
+		return errors.New("message it's empty")
+	}
+	if message.Body == "" {
+		return errors.New("sms body it's empty")
+	}
+
+	message.Provider = providerName
+	request := SendSMSRequest{
+		To:   message.Target,
+		From: s.sender,
+		Body: message.Body,
+	}
+
+	var result SendSMSResponse
+	var errorResponse ErrorResponse
+	response, err := s.RESTClient.R().
+		SetBody(request).
+		SetResult(&result).
+		SetError(&errorResponse).
+		Post(sendSMSEndpoint)
+	if err != nil {
+		return err
+	}
+	if response.IsError() {
+		return fmt.Errorf("sms provider response error with status: %d, code: %s, message: %s",
+			response.StatusCode(), errorResponse.Code, errorResponse.Message)
+	}
+	message.ProviderMessageID = result.MessageID
+	return nil
+}
+
+// New return a new instance of the HTTP SMS provider configured from the environment
+func New() smsclient.SMSClient {
+	return NewWithURL(os.Getenv("SMS_API_URL"), os.Getenv("SMS_API_KEY"), os.Getenv("SMS_SENDER_ID"))
+}
+
+// NewWithURL return a new instance talking to the provider at baseURL, such as a local stub
+func NewWithURL(baseURL, apiKey, sender string) smsclient.SMSClient {
+	// Build REST client
+	restClient := resty.New()
+	restClient.SetBaseURL(baseURL)
+	restClient.SetHeader("Content-Type", "application/json")
+	restClient.SetHeader("Accept", "application/json")
+	restClient.SetAuthToken(apiKey)
+
+	return &smsClient{
+		RESTClient: restClient,
+		sender:     sender,
+	}
+}
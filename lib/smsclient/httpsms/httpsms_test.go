@@ -0,0 +1,76 @@
+package httpsms
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"waitlist/models"
+)
+
+func TestSendRequest(t *testing.T) {
+	var got SendSMSRequest
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != sendSMSEndpoint {
+			t.Errorf("unexpected call %s %s", r.Method, r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer key" {
+			t.Errorf("Authorization = %q, want %q", auth, "Bearer key")
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(SendSMSResponse{MessageID: "sms-1", Status: "queued"})
+	}))
+	defer server.Close()
+
+	message := &models.Message{Type: models.SMS_MESSAGE_TYPE, Target: "+15550100", Body: "You're in"}
+	if err := NewWithURL(server.URL, "key", "Waitlist").Send(message); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	want := SendSMSRequest{To: "+15550100", From: "Waitlist", Body: "You're in"}
+	if got != want {
+		t.Errorf("request = %+v, want %+v", got, want)
+	}
+	if message.ProviderMessageID != "sms-1" || message.Provider != providerName {
+		t.Errorf("message = %+v, want the provider message id set", message)
+	}
+}
+
+func TestSendErrorResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(rw).Encode(ErrorResponse{Code: "invalid_number", Message: "to is not a valid number"})
+	}))
+	defer server.Close()
+
+	message := &models.Message{Type: models.SMS_MESSAGE_TYPE, Target: "12", Body: "You're in"}
+	err := NewWithURL(server.URL, "key", "Waitlist").Send(message)
+	if err == nil {
+		t.Fatal("Send: expected an error")
+	}
+	for _, part := range []string{"400", "invalid_number", "to is not a valid number"} {
+		if !strings.Contains(err.Error(), part) {
+			t.Errorf("error %q doesn't mention %q", err, part)
+		}
+	}
+	if message.ProviderMessageID != "" {
+		t.Errorf("provider message id = %q, want none", message.ProviderMessageID)
+	}
+}
+
+func TestSendEmptyBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected call %s %s", r.Method, r.URL.Path)
+	}))
+	defer server.Close()
+
+	message := &models.Message{Type: models.SMS_MESSAGE_TYPE, Target: "+15550100"}
+	if err := NewWithURL(server.URL, "key", "Waitlist").Send(message); err == nil {
+		t.Fatal("Send: expected an error for an empty body")
+	}
+}
@@ -0,0 +1,8 @@
+package smsclient
+
+import "waitlist/models"
+
+// SMSClient interface
+type SMSClient interface {
+	Send(sms *models.Message) error
+}
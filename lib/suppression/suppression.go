@@ -0,0 +1,125 @@
+package suppression
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"waitlist/lib/emailclient"
+	"waitlist/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNotFound is returned when removing a value that is not suppressed
+var ErrNotFound = errors.New("suppression not found")
+
+// Ensure implementation of SuppressionChecker interface
+var _ emailclient.SuppressionChecker = (*Store)(nil)
+
+// Store is the Mongo backed suppression list. Every tenant has its own list,
+// since bounces and complaints are tracked per sender.
+type Store struct {
+	collection *mongo.Collection
+}
+
+// New returns a Store backed by the suppressions collection of db
+func New(db *mongo.Database) *Store {
+	collection := db.Collection("suppressions")
+
+	// values used to be unique across the whole deployment
+	collection.Indexes().DropOne(context.Background(), "value_1")
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "tenant_id", Value: 1}, {Key: "value", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := collection.Indexes().CreateOne(context.Background(), index); err != nil {
+		log.Println("unable to create suppressions index:", err)
+	}
+
+	return &Store{collection: collection}
+}
+
+// IsSuppressed reports whether the address or its domain is on the list of tenant
+func (s *Store) IsSuppressed(ctx context.Context, tenant string, address string) (bool, error) {
+	address = Normalize(address)
+	values := []string{address}
+	if domain := domainOf(address); domain != "" {
+		values = append(values, domain)
+	}
+
+	count, err := s.collection.CountDocuments(ctx, bson.M{"tenant_id": tenant, "value": bson.M{"$in": values}})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// List returns every suppression of tenant, newest first
+func (s *Store) List(ctx context.Context, tenant string) ([]models.Suppression, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	cursor, err := s.collection.Find(ctx, bson.M{"tenant_id": tenant}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	suppressions := []models.Suppression{}
+	if err := cursor.All(ctx, &suppressions); err != nil {
+		return nil, err
+	}
+	return suppressions, nil
+}
+
+// Add suppresses value for tenant, replacing the reason if it is already on the list
+func (s *Store) Add(ctx context.Context, tenant string, value string, reason models.SuppressionReason) (*models.Suppression, error) {
+	entry := models.Suppression{
+		Value:     Normalize(value),
+		Kind:      models.EMAIL_SUPPRESSION_KIND,
+		Reason:    reason,
+		Timestamp: time.Now().Unix(),
+	}
+	if !strings.Contains(entry.Value, "@") {
+		entry.Kind = models.DOMAIN_SUPPRESSION_KIND
+	}
+
+	filter := bson.M{"tenant_id": tenant, "value": entry.Value}
+	update := bson.M{"$set": bson.M{
+		"kind":      entry.Kind,
+		"reason":    entry.Reason,
+		"timestamp": entry.Timestamp,
+	}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	if err := s.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Remove takes value off the list of tenant
+func (s *Store) Remove(ctx context.Context, tenant string, value string) error {
+	result, err := s.collection.DeleteOne(ctx, bson.M{"tenant_id": tenant, "value": Normalize(value)})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Normalize lower-cases and trims an address or domain
+func Normalize(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+func domainOf(address string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return ""
+	}
+	return address[at+1:]
+}
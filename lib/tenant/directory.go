@@ -0,0 +1,71 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+	"waitlist/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// directoryTTL is how long an organization is served from memory
+const directoryTTL = time.Minute
+
+// ErrNotFound is returned when no organization has the requested id
+var ErrNotFound = errors.New("organization not found")
+
+// Directory looks organizations up by id, caching them briefly since every
+// outgoing email needs its sender's credentials
+type Directory struct {
+	collection *mongo.Collection
+
+	mu    sync.Mutex
+	cache map[string]cachedOrganization
+}
+
+type cachedOrganization struct {
+	organization models.Organization
+	expires      time.Time
+}
+
+// NewDirectory returns a Directory backed by the organizations collection of db
+func NewDirectory(db *mongo.Database) *Directory {
+	return &Directory{
+		collection: db.Collection("organizations"),
+		cache:      map[string]cachedOrganization{},
+	}
+}
+
+// Get returns the organization with the given id
+func (d *Directory) Get(ctx context.Context, id string) (*models.Organization, error) {
+	d.mu.Lock()
+	cached, ok := d.cache[id]
+	d.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		organization := cached.organization
+		return &organization, nil
+	}
+
+	organization := models.Organization{}
+	err := d.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&organization)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	d.cache[id] = cachedOrganization{organization: organization, expires: time.Now().Add(directoryTTL)}
+	d.mu.Unlock()
+	return &organization, nil
+}
+
+// Invalidate drops the cached copy of an organization after it changed
+func (d *Directory) Invalidate(id string) {
+	d.mu.Lock()
+	delete(d.cache, id)
+	d.mu.Unlock()
+}
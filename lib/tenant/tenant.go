@@ -0,0 +1,198 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Default is the tenant owning everything created before organizations existed
+const Default = "default"
+
+// Key is the field holding the tenant of every scoped document
+const Key = "tenant_id"
+
+// Database hands out collections scoped to a single tenant
+type Database struct {
+	db     *mongo.Database
+	tenant string
+}
+
+// Scope returns db restricted to tenant
+func Scope(db *mongo.Database, tenant string) *Database {
+	return &Database{db: db, tenant: tenant}
+}
+
+// Tenant returns the id of the tenant the database is scoped to
+func (d *Database) Tenant() string {
+	return d.tenant
+}
+
+// Collection returns the named collection scoped to the tenant
+func (d *Database) Collection(name string) *Collection {
+	return &Collection{collection: d.db.Collection(name), tenant: d.tenant}
+}
+
+// Collection mirrors the subset of mongo.Collection used by the controllers.
+// Every filter is narrowed to the tenant and every inserted document is
+// stamped with it, so callers can't read or write another tenant's data.
+type Collection struct {
+	collection *mongo.Collection
+	tenant     string
+}
+
+func (c *Collection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	return c.collection.Find(ctx, c.filter(filter), opts...)
+}
+
+func (c *Collection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	return c.collection.FindOne(ctx, c.filter(filter), opts...)
+}
+
+func (c *Collection) FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	return c.collection.FindOneAndUpdate(ctx, c.filter(filter), update, opts...)
+}
+
+func (c *Collection) FindOneAndDelete(ctx context.Context, filter interface{}, opts ...*options.FindOneAndDeleteOptions) *mongo.SingleResult {
+	return c.collection.FindOneAndDelete(ctx, c.filter(filter), opts...)
+}
+
+func (c *Collection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	doc, err := c.stamp(document)
+	if err != nil {
+		return nil, err
+	}
+	return c.collection.InsertOne(ctx, doc, opts...)
+}
+
+func (c *Collection) InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	docs := make([]interface{}, len(documents))
+	for i, document := range documents {
+		doc, err := c.stamp(document)
+		if err != nil {
+			return nil, err
+		}
+		docs[i] = doc
+	}
+	return c.collection.InsertMany(ctx, docs, opts...)
+}
+
+// UpdateOne narrows filter to the tenant. Upserted documents inherit the tenant from the filter.
+func (c *Collection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.collection.UpdateOne(ctx, c.filter(filter), update, opts...)
+}
+
+func (c *Collection) UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.collection.UpdateMany(ctx, c.filter(filter), update, opts...)
+}
+
+func (c *Collection) DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return c.collection.DeleteOne(ctx, c.filter(filter), opts...)
+}
+
+func (c *Collection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return c.collection.DeleteMany(ctx, c.filter(filter), opts...)
+}
+
+func (c *Collection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	return c.collection.CountDocuments(ctx, c.filter(filter), opts...)
+}
+
+// Aggregate runs pipeline over the tenant's documents only. Stages that read
+// other collections, such as $lookup, must match on tenant specific keys.
+func (c *Collection) Aggregate(ctx context.Context, pipeline bson.A, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	scoped := append(bson.A{bson.M{"$match": bson.M{Key: c.tenant}}}, pipeline...)
+	return c.collection.Aggregate(ctx, scoped, opts...)
+}
+
+// BulkWrite narrows the filter of every write to the tenant and stamps
+// inserted and replacement documents with it.
+func (c *Collection) BulkWrite(ctx context.Context, writes []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	scoped := make([]mongo.WriteModel, len(writes))
+	for i, write := range writes {
+		switch m := write.(type) {
+		case *mongo.InsertOneModel:
+			doc, err := c.stamp(m.Document)
+			if err != nil {
+				return nil, err
+			}
+			scoped[i] = mongo.NewInsertOneModel().SetDocument(doc)
+		case *mongo.UpdateOneModel:
+			model := *m
+			model.Filter = c.filter(m.Filter)
+			scoped[i] = &model
+		case *mongo.UpdateManyModel:
+			model := *m
+			model.Filter = c.filter(m.Filter)
+			scoped[i] = &model
+		case *mongo.ReplaceOneModel:
+			doc, err := c.stamp(m.Replacement)
+			if err != nil {
+				return nil, err
+			}
+			model := *m
+			model.Filter = c.filter(m.Filter)
+			model.Replacement = doc
+			scoped[i] = &model
+		case *mongo.DeleteOneModel:
+			model := *m
+			model.Filter = c.filter(m.Filter)
+			scoped[i] = &model
+		case *mongo.DeleteManyModel:
+			model := *m
+			model.Filter = c.filter(m.Filter)
+			scoped[i] = &model
+		default:
+			return nil, fmt.Errorf("tenant: unsupported write model %T", write)
+		}
+	}
+	return c.collection.BulkWrite(ctx, scoped, opts...)
+}
+
+// filter adds the tenant to filter
+func (c *Collection) filter(filter interface{}) interface{} {
+	switch f := filter.(type) {
+	case nil:
+		return bson.M{Key: c.tenant}
+	case bson.M:
+		scoped := make(bson.M, len(f)+1)
+		for key, value := range f {
+			scoped[key] = value
+		}
+		scoped[Key] = c.tenant
+		return scoped
+	case bson.D:
+		scoped := make(bson.D, 0, len(f)+1)
+		for _, e := range f {
+			if e.Key != Key {
+				scoped = append(scoped, e)
+			}
+		}
+		return append(scoped, bson.E{Key: Key, Value: c.tenant})
+	default:
+		return bson.M{"$and": bson.A{filter, bson.M{Key: c.tenant}}}
+	}
+}
+
+// stamp returns document with its tenant set, whatever it was before
+func (c *Collection) stamp(document interface{}) (bson.D, error) {
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+
+	stamped := make(bson.D, 0, len(doc)+1)
+	for _, e := range doc {
+		if e.Key != Key {
+			stamped = append(stamped, e)
+		}
+	}
+	return append(stamped, bson.E{Key: Key, Value: c.tenant}), nil
+}
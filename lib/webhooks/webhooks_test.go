@@ -0,0 +1,115 @@
+package webhooks
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"waitlist/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDeliverSignsPayload(t *testing.T) {
+	delivery := &models.WebhookDelivery{
+		ID:      primitive.NewObjectID(),
+		Event:   models.ENTRY_CREATED_EVENT_TYPE,
+		Payload: `{"id":"1","type":"entry.created"}`,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != delivery.Payload {
+			t.Errorf("body = %s, want %s", body, delivery.Payload)
+		}
+		timestamp := r.Header.Get(TimestampHeader)
+		if want := "sha256=" + Sign("whsec_test", timestamp, body); r.Header.Get(SignatureHeader) != want {
+			t.Errorf("%s = %q, want %q", SignatureHeader, r.Header.Get(SignatureHeader), want)
+		}
+		if r.Header.Get(EventHeader) != string(delivery.Event) {
+			t.Errorf("%s = %q, want %q", EventHeader, r.Header.Get(EventHeader), delivery.Event)
+		}
+		if r.Header.Get(DeliveryHeader) != delivery.ID.Hex() {
+			t.Errorf("%s = %q, want %q", DeliveryHeader, r.Header.Get(DeliveryHeader), delivery.ID.Hex())
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	status, err := New(server.Client()).Deliver(server.URL, "whsec_test", delivery)
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("Deliver = %d, %v, want 204", status, err)
+	}
+}
+
+func TestDeliverFailureKeepsNoBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(rw, "secret internal details")
+	}))
+	defer server.Close()
+
+	status, err := New(server.Client()).Deliver(server.URL, "whsec_test", &models.WebhookDelivery{Payload: "{}"})
+	if err == nil || status != http.StatusInternalServerError {
+		t.Fatalf("Deliver = %d, %v, want 500 and an error", status, err)
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error %q contains the response body", err)
+	}
+}
+
+func TestPublicClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		t.Error("the receiver was contacted")
+	}))
+	defer server.Close()
+
+	_, err := New(nil).Deliver(server.URL, "whsec_test", &models.WebhookDelivery{Payload: "{}"})
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("Deliver to %s = %v, want ErrPrivateAddress", server.URL, err)
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::ffff:10.0.0.1": false,
+	}
+	for address, want := range tests {
+		if got := IsPublic(net.ParseIP(address)); got != want {
+			t.Errorf("IsPublic(%s) = %v, want %v", address, got, want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	if Backoff(1) != firstRetry {
+		t.Errorf("Backoff(1) = %s, want %s", Backoff(1), firstRetry)
+	}
+	previous := time.Duration(0)
+	for attempts := 1; attempts < MaxAttempts; attempts++ {
+		delay := Backoff(attempts)
+		if delay < previous || delay > maxRetry {
+			t.Errorf("Backoff(%d) = %s after %s", attempts, delay, previous)
+		}
+		previous = delay
+	}
+	if Backoff(100) != maxRetry {
+		t.Errorf("Backoff(100) = %s, want %s", Backoff(100), maxRetry)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"waitlist/middleware"
 	"waitlist/routes"
@@ -40,6 +41,25 @@ func main() {
 	// Setup routes with AuthMiddleware
 	routes.SetupRoutes(router, authConn)
 
+	// Serve TLS when a certificate is configured, optionally verifying client
+	// certificates for service to service calls
+	certFile := os.Getenv("TLS_CERT_FILE")
+	keyFile := os.Getenv("TLS_KEY_FILE")
+	if certFile != "" && keyFile != "" {
+		server := &http.Server{Addr: portAddress, Handler: router}
+		if caFile := os.Getenv("TLS_CLIENT_CA_FILE"); caFile != "" {
+			tlsConfig, err := middleware.ClientCertTLSConfig(caFile)
+			if err != nil {
+				log.Fatal("Unable to load client CA: ", err)
+			}
+			server.TLSConfig = tlsConfig
+		}
+		if err := server.ListenAndServeTLS(certFile, keyFile); err != nil {
+			log.Fatal("Unable to start router: ", err)
+		}
+		return
+	}
+
 	if err := router.Run(portAddress); err != nil {
 		log.Fatal("Unable to start router: ", err)
 	}
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"waitlist/lib/audit"
+	"waitlist/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Keys of the values handlers hand to AuditMiddleware through the gin context
+const (
+	RequestIDKey   = "request_id"
+	auditTargetKey = "audit_target"
+	auditBeforeKey = "audit_before"
+	auditAfterKey  = "audit_after"
+)
+
+// RequestIDHeader carries the id of a request, taken from the caller when set
+const RequestIDHeader = "X-Request-ID"
+
+// AuditMiddleware records every change an admin makes into trail, with the
+// state handlers report through AuditTarget and AuditState. Reads are not
+// recorded. It must run after AuthMiddleware.
+func AuditMiddleware(trail *audit.Log) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		c.Set(RequestIDKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+
+		c.Next()
+
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			return
+		}
+
+		target := c.GetString(auditTargetKey)
+		if target == "" {
+			params := make([]string, len(c.Params))
+			for i, param := range c.Params {
+				params[i] = auditParam(param.Key, param.Value)
+			}
+			target = strings.Join(params, " ")
+		}
+		record := models.AuditRecord{
+			TenantID:  c.GetString(TenantKey),
+			Actor:     c.GetString(EmailKey),
+			Action:    c.Request.Method + " " + c.FullPath(),
+			Target:    target,
+			RequestID: requestID,
+			IP:        c.ClientIP(),
+			Status:    c.Writer.Status(),
+			Timestamp: time.Now().Unix(),
+		}
+		if before, ok := c.Get(auditBeforeKey); ok {
+			record.Before = before.(json.RawMessage)
+		}
+		if after, ok := c.Get(auditAfterKey); ok {
+			record.After = after.(json.RawMessage)
+		}
+
+		if err := trail.Append(context.Background(), &record); err != nil {
+			log.Println("unable to append audit record:", requestID, err)
+		}
+	}
+}
+
+// auditParam renders a route parameter for the audit log. The log can't be
+// erased, so addresses are only kept as a hash.
+func auditParam(key, value string) string {
+	if key != "email" && key != "value" {
+		return key + "=" + value
+	}
+	return AuditHash(key, value)
+}
+
+// AuditHash names an address in the audit log by the hash of its
+// normalized form, as key_sha256=<hex>
+func AuditHash(key, value string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(value))))
+	return key + "_sha256=" + hex.EncodeToString(sum[:])
+}
+
+// AuditTarget names what the request acted on, instead of its route parameters
+func AuditTarget(c *gin.Context, target string) {
+	c.Set(auditTargetKey, target)
+}
+
+// AuditState records the state of the target before and after the request.
+// Either can be nil, for creations and deletions.
+func AuditState(c *gin.Context, before, after interface{}) {
+	if before != nil {
+		if state, err := json.Marshal(before); err == nil {
+			c.Set(auditBeforeKey, json.RawMessage(state))
+		}
+	}
+	if after != nil {
+		if state, err := json.Marshal(after); err == nil {
+			c.Set(auditAfterKey, json.RawMessage(state))
+		}
+	}
+}
+
+// newRequestID returns a random id for requests that come without one
+func newRequestID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(buf)
+}
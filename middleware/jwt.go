@@ -34,9 +34,11 @@ func NewAuthConn(privateKey, publicKey string) *AuthConn {
 	}
 }
 
-func (a *AuthConn) GenerateJWT(email string) (string, error) {
+// GenerateJWT issues a token for the admin email acting for organization
+func (a *AuthConn) GenerateJWT(email string, organization string) (string, error) {
 	claims := jwt.MapClaims{
 		"email": email,
+		"org":   organization,
 		"exp":   time.Now().Add(time.Hour * 24).Unix(),
 	}
 
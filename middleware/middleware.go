@@ -1,9 +1,18 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"net/http"
+	"waitlist/lib/tenant"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Keys of the values AuthMiddleware stores in the gin context
+const (
+	EmailKey  = "email"
+	TenantKey = "tenant"
 )
 
 func CORSMiddleware() gin.HandlerFunc {
@@ -12,7 +21,7 @@ func CORSMiddleware() gin.HandlerFunc {
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "false")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
-		c.Writer.Header().Set("Access-Control-Expose-Headers", "Authorization")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Authorization, X-Request-ID")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -33,12 +42,62 @@ func AuthMiddleware(authConn *AuthConn) gin.HandlerFunc {
 			return
 		}
 
-		_, err := authConn.ValidateJWT(authToken)
+		token, err := authConn.ValidateJWT(authToken)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "could not validate auth token"})
 			return
 		}
 
+		claims, _ := token.Claims.(jwt.MapClaims)
+		email, _ := claims["email"].(string)
+		organization, ok := claims["org"].(string)
+		if !ok {
+			// tokens issued before organizations existed act for the default one
+			organization = tenant.Default
+		}
+		c.Set(EmailKey, email)
+		c.Set(TenantKey, organization)
+
+		c.Next()
+	}
+}
+
+// TenantMiddleware rejects admins that have no active organization yet. It
+// must run after AuthMiddleware.
+func TenantMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString(TenantKey) == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "no active organization, create or switch to one first"})
+			return
+		}
+
 		c.Next()
 	}
 }
+
+// ServiceAuthMiddleware authenticates service to service calls, either with a
+// client certificate verified by the TLS listener or with one of apiKeys in the
+// X-API-Key header
+func ServiceAuthMiddleware(apiKeys []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0 {
+			c.Next()
+			return
+		}
+
+		key := c.GetHeader("X-API-Key")
+		if key == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "no api key provided"})
+			return
+		}
+
+		for _, apiKey := range apiKeys {
+			if apiKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "could not validate api key"})
+	}
+}
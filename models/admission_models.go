@@ -0,0 +1,49 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// AdmissionSettings drives the automatic admission scheduler of one waitlist
+type AdmissionSettings struct {
+	Waitlist       string          `json:"waitlist" bson:"_id"`
+	TenantID       string          `json:"-" bson:"tenant_id,omitempty"`
+	Quota          int             `json:"quota" bson:"quota"`
+	Period         AdmissionPeriod `json:"period" bson:"period"`
+	ExpiresInHours int64           `json:"expires_in_hours" bson:"expires_in_hours"`
+	Paused         bool            `json:"paused" bson:"paused"`
+	LastRunAt      int64           `json:"last_run_at,omitempty" bson:"last_run_at,omitempty"`
+	NextRunAt      int64           `json:"next_run_at" bson:"next_run_at"`
+	UpdatedAt      int64           `json:"updated_at" bson:"updated_at"`
+}
+
+// AdmissionPeriod enum type, how often the quota is admitted
+type AdmissionPeriod string
+
+const (
+	DAILY_ADMISSION_PERIOD  AdmissionPeriod = "day"
+	WEEKLY_ADMISSION_PERIOD AdmissionPeriod = "week"
+)
+
+// Days returns the length of the period in days, zero for unknown periods
+func (p AdmissionPeriod) Days() int {
+	switch p {
+	case DAILY_ADMISSION_PERIOD:
+		return 1
+	case WEEKLY_ADMISSION_PERIOD:
+		return 7
+	}
+	return 0
+}
+
+// AdmissionRun records one execution of the admission scheduler
+type AdmissionRun struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Waitlist    string             `json:"waitlist" bson:"waitlist"`
+	Owner       string             `json:"owner" bson:"owner"`
+	Quota       int                `json:"quota" bson:"quota"`
+	Admitted    int                `json:"admitted" bson:"admitted"`
+	Skipped     int                `json:"skipped" bson:"skipped"`
+	WaveID      primitive.ObjectID `json:"wave_id" bson:"wave_id"`
+	Error       string             `json:"error,omitempty" bson:"error,omitempty"`
+	StartedAt   int64              `json:"started_at" bson:"started_at"`
+	CompletedAt int64              `json:"completed_at" bson:"completed_at"`
+}
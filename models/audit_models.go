@@ -0,0 +1,27 @@
+package models
+
+import (
+	"encoding/json"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditRecord is one admin action in the append-only audit log. Records of
+// an organization form a chain: each one carries the hash of the previous one
+// and a hash of its own content, so edits and deletions can be detected.
+type AuditRecord struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TenantID  string             `json:"-" bson:"tenant_id"`
+	Seq       int64              `json:"seq" bson:"seq"`
+	Actor     string             `json:"actor" bson:"actor"`
+	Action    string             `json:"action" bson:"action"`
+	Target    string             `json:"target,omitempty" bson:"target,omitempty"`
+	RequestID string             `json:"request_id" bson:"request_id"`
+	IP        string             `json:"ip" bson:"ip"`
+	Status    int                `json:"status" bson:"status"`
+	Before    json.RawMessage    `json:"before,omitempty" bson:"before,omitempty"`
+	After     json.RawMessage    `json:"after,omitempty" bson:"after,omitempty"`
+	Timestamp int64              `json:"timestamp" bson:"timestamp"`
+	PrevHash  string             `json:"prev_hash" bson:"prev_hash"`
+	Hash      string             `json:"hash" bson:"hash"`
+}
@@ -0,0 +1,80 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// BulkJob is a bulk operation too large to answer inline. It is processed in
+// the background and polled by id, its per-entry results kept as BulkItems.
+type BulkJob struct {
+	ID       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TenantID string             `json:"-" bson:"tenant_id,omitempty"`
+	Waitlist string             `json:"waitlist" bson:"waitlist"`
+	Action   BulkAction         `json:"action" bson:"action"`
+	Emails   []string           `json:"emails,omitempty" bson:"emails,omitempty"`
+	Filter   *BulkFilter        `json:"filter,omitempty" bson:"filter,omitempty"`
+	Tags     []string           `json:"tags,omitempty" bson:"tags,omitempty"`
+	// Status is the entry status set by a status action, waiting or accepted
+	Status      EntryStatus      `json:"status,omitempty" bson:"status,omitempty"`
+	State       BulkJobState     `json:"state" bson:"state"`
+	Total       int64            `json:"total" bson:"total"`
+	Processed   int64            `json:"processed" bson:"processed"`
+	Results     map[string]int64 `json:"results" bson:"results"`
+	Error       string           `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedBy   string           `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	CreatedAt   int64            `json:"created_at" bson:"created_at"`
+	UpdatedAt   int64            `json:"updated_at" bson:"updated_at"`
+	CompletedAt int64            `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+}
+
+// BulkFilter selects the entries of a waitlist a bulk operation applies to.
+// An empty filter matches every entry.
+type BulkFilter struct {
+	Status         EntryStatus `json:"status,omitempty" bson:"status,omitempty"`
+	Confirmed      *bool       `json:"confirmed,omitempty" bson:"confirmed,omitempty"`
+	SignedUpAfter  int64       `json:"signed_up_after,omitempty" bson:"signed_up_after,omitempty"`
+	SignedUpBefore int64       `json:"signed_up_before,omitempty" bson:"signed_up_before,omitempty"`
+	// Fields matches custom signup fields, keyed by field key
+	Fields map[string]string `json:"fields,omitempty" bson:"fields,omitempty"`
+	// Tags matches entries carrying all of the tags
+	Tags []string `json:"tags,omitempty" bson:"tags,omitempty"`
+}
+
+// BulkItem is the outcome of a bulk operation for one email
+type BulkItem struct {
+	ID       primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	TenantID string             `json:"-" bson:"tenant_id,omitempty"`
+	JobID    primitive.ObjectID `json:"-" bson:"job_id,omitempty"`
+	Email    string             `json:"email" bson:"email"`
+	Result   BulkResult         `json:"result" bson:"result"`
+	Error    string             `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// BulkAction enum type
+type BulkAction string
+
+const (
+	DELETE_BULK_ACTION BulkAction = "delete"
+	TAG_BULK_ACTION    BulkAction = "tag"
+	UNTAG_BULK_ACTION  BulkAction = "untag"
+	STATUS_BULK_ACTION BulkAction = "status"
+)
+
+// BulkJobState enum type
+type BulkJobState string
+
+const (
+	QUEUED_BULK_JOB_STATE    BulkJobState = "queued"
+	RUNNING_BULK_JOB_STATE   BulkJobState = "running"
+	COMPLETED_BULK_JOB_STATE BulkJobState = "completed"
+	FAILED_BULK_JOB_STATE    BulkJobState = "failed"
+)
+
+// BulkResult enum type
+type BulkResult string
+
+const (
+	UPDATED_BULK_RESULT   BulkResult = "updated"
+	DELETED_BULK_RESULT   BulkResult = "deleted"
+	UNCHANGED_BULK_RESULT BulkResult = "unchanged"
+	NOT_FOUND_BULK_RESULT BulkResult = "not_found"
+	FAILED_BULK_RESULT    BulkResult = "failed"
+)
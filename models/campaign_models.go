@@ -0,0 +1,107 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Campaign is a one-off broadcast of a template to an audience of the waitlist
+type Campaign struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TenantID      string             `json:"-" bson:"tenant_id,omitempty"`
+	Name          string             `json:"name" bson:"name"`
+	TemplateAlias string             `json:"template_alias" bson:"template_alias"`
+	Audience      AudienceFilter     `json:"audience" bson:"audience"`
+	ScheduledAt   int64              `json:"scheduled_at" bson:"scheduled_at"`
+	Status        CampaignStatus     `json:"status" bson:"status"`
+	Materialized  bool               `json:"-" bson:"materialized"`
+	Progress      CampaignProgress   `json:"progress" bson:"progress"`
+	CreatedAt     int64              `json:"created_at" bson:"created_at"`
+	StartedAt     int64              `json:"started_at,omitempty" bson:"started_at,omitempty"`
+	CompletedAt   int64              `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+}
+
+// CampaignProgress aggregates the status of every recipient of a campaign
+type CampaignProgress struct {
+	Total   int64 `json:"total" bson:"total"`
+	Queued  int64 `json:"queued" bson:"queued"`
+	Sent    int64 `json:"sent" bson:"sent"`
+	Failed  int64 `json:"failed" bson:"failed"`
+	Skipped int64 `json:"skipped" bson:"skipped"`
+}
+
+// AudienceFilter selects the waitlist entries a campaign is sent to
+type AudienceFilter struct {
+	Waitlist       string   `json:"waitlist,omitempty" bson:"waitlist,omitempty"`
+	SignedUpAfter  int64    `json:"signed_up_after,omitempty" bson:"signed_up_after,omitempty"`
+	SignedUpBefore int64    `json:"signed_up_before,omitempty" bson:"signed_up_before,omitempty"`
+	Emails         []string `json:"emails,omitempty" bson:"emails,omitempty"`
+	ConfirmedOnly  bool     `json:"confirmed_only,omitempty" bson:"confirmed_only,omitempty"`
+	// Tags narrows the audience to entries carrying all of them
+	Tags []string `json:"tags,omitempty" bson:"tags,omitempty"`
+}
+
+// Query returns the waitlist filter for the audience. Entries that opted out are
+// never included, and an audience without a waitlist targets the default one.
+func (a AudienceFilter) Query() bson.M {
+	query := bson.M{"opt_out": bson.M{"$exists": false}, "deleted_at": bson.M{"$exists": false}, "waitlist": a.Waitlist}
+	if a.Waitlist == "" {
+		query["waitlist"] = DefaultWaitlist
+	}
+
+	timestamp := bson.M{}
+	if a.SignedUpAfter > 0 {
+		timestamp["$gte"] = a.SignedUpAfter
+	}
+	if a.SignedUpBefore > 0 {
+		timestamp["$lt"] = a.SignedUpBefore
+	}
+	if len(timestamp) > 0 {
+		query["timestamp"] = timestamp
+	}
+
+	if len(a.Emails) > 0 {
+		query["email"] = bson.M{"$in": a.Emails}
+	}
+	if a.ConfirmedOnly {
+		query["confirmed_at"] = bson.M{"$gt": 0}
+	}
+	if tags := NormalizeTags(a.Tags); len(tags) > 0 {
+		query["tags"] = bson.M{"$all": tags}
+	}
+	return query
+}
+
+// CampaignStatus enum type
+type CampaignStatus string
+
+const (
+	SCHEDULED_CAMPAIGN_STATUS CampaignStatus = "scheduled"
+	RUNNING_CAMPAIGN_STATUS   CampaignStatus = "running"
+	PAUSED_CAMPAIGN_STATUS    CampaignStatus = "paused"
+	CANCELLED_CAMPAIGN_STATUS CampaignStatus = "cancelled"
+	COMPLETED_CAMPAIGN_STATUS CampaignStatus = "completed"
+)
+
+// CampaignRecipient tracks delivery of a campaign to a single address
+type CampaignRecipient struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	CampaignID primitive.ObjectID `json:"campaign_id" bson:"campaign_id"`
+	EntryID    primitive.ObjectID `json:"entry_id" bson:"entry_id"`
+	Email      string             `json:"email" bson:"email"`
+	Status     RecipientStatus    `json:"status" bson:"status"`
+	Error      string             `json:"error,omitempty" bson:"error,omitempty"`
+	UpdatedAt  int64              `json:"updated_at" bson:"updated_at"`
+}
+
+// RecipientStatus enum type
+type RecipientStatus string
+
+const (
+	QUEUED_RECIPIENT_STATUS    RecipientStatus = "queued"
+	SENDING_RECIPIENT_STATUS   RecipientStatus = "sending"
+	SENT_RECIPIENT_STATUS      RecipientStatus = "sent"
+	FAILED_RECIPIENT_STATUS    RecipientStatus = "failed"
+	SKIPPED_RECIPIENT_STATUS   RecipientStatus = "skipped"
+	CANCELLED_RECIPIENT_STATUS RecipientStatus = "cancelled"
+)
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DripSequence is a series of emails sent at fixed delays after signup
+type DripSequence struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TenantID  string             `json:"-" bson:"tenant_id,omitempty"`
+	Waitlist  string             `json:"waitlist" bson:"waitlist"`
+	Name      string             `json:"name" bson:"name"`
+	Active    bool               `json:"active" bson:"active"`
+	Steps     []DripStep         `json:"steps" bson:"steps"`
+	StartsAt  int64              `json:"starts_at" bson:"starts_at"`
+	CreatedAt int64              `json:"created_at" bson:"created_at"`
+}
+
+// DripStep is a single email of a sequence. Key must be unique within the sequence.
+type DripStep struct {
+	Key           string         `json:"key" bson:"key"`
+	DelayDays     int            `json:"delay_days" bson:"delay_days"`
+	TemplateAlias string         `json:"template_alias" bson:"template_alias"`
+	Conditions    DripConditions `json:"conditions" bson:"conditions"`
+}
+
+// DripConditions restricts a step to entries in a given state
+type DripConditions struct {
+	StillWaiting bool `json:"still_waiting" bson:"still_waiting"`
+	NoReferrals  bool `json:"no_referrals" bson:"no_referrals"`
+}
+
+// Query returns the waitlist filter for the conditions
+func (d DripConditions) Query() bson.M {
+	query := bson.M{}
+	if d.StillWaiting {
+		// entries created before statuses existed are still waiting
+		query["status"] = bson.M{"$in": bson.A{nil, WAITING_ENTRY_STATUS}}
+	}
+	if d.NoReferrals {
+		query["referral_count"] = bson.M{"$in": bson.A{nil, 0}}
+	}
+	return query
+}
+
+// DripMessage is a queued step of a sequence for one entry. Key is unique per
+// sequence, step and email so nobody is enqueued for the same step twice.
+type DripMessage struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TenantID   string             `json:"-" bson:"tenant_id,omitempty"`
+	Key        string             `json:"key" bson:"key"`
+	SequenceID primitive.ObjectID `json:"sequence_id" bson:"sequence_id"`
+	StepKey    string             `json:"step_key" bson:"step_key"`
+	Email      string             `json:"email" bson:"email"`
+	Message    Message            `json:"message" bson:"message"`
+	Status     RecipientStatus    `json:"status" bson:"status"`
+	Error      string             `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt  int64              `json:"created_at" bson:"created_at"`
+	UpdatedAt  int64              `json:"updated_at" bson:"updated_at"`
+}
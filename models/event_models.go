@@ -0,0 +1,32 @@
+package models
+
+// Event is something that happened to a waitlist entry, streamed to dashboards
+type Event struct {
+	ID       uint64         `json:"id"`
+	Type     EventType      `json:"type"`
+	TenantID string         `json:"-"`
+	Waitlist string         `json:"waitlist"`
+	Email    string         `json:"email"`
+	Entry    *WaitlistEntry `json:"entry,omitempty"`
+	Invite   *Invite        `json:"invite,omitempty"`
+	Time     int64          `json:"time"`
+}
+
+// EventType enum type
+type EventType string
+
+const (
+	ENTRY_CREATED_EVENT_TYPE   EventType = "entry.created"
+	ENTRY_CONFIRMED_EVENT_TYPE EventType = "entry.confirmed"
+	ENTRY_DELETED_EVENT_TYPE   EventType = "entry.deleted"
+	INVITE_SENT_EVENT_TYPE     EventType = "invite.sent"
+)
+
+// Valid reports whether t is a known event type
+func (t EventType) Valid() bool {
+	switch t {
+	case ENTRY_CREATED_EVENT_TYPE, ENTRY_CONFIRMED_EVENT_TYPE, ENTRY_DELETED_EVENT_TYPE, INVITE_SENT_EVENT_TYPE:
+		return true
+	}
+	return false
+}
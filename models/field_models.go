@@ -0,0 +1,21 @@
+package models
+
+// SignupField describes one custom field collected at signup. Values are
+// stored in the metadata map of the entry under Key.
+type SignupField struct {
+	Key       string    `json:"key" bson:"key"`
+	Label     string    `json:"label,omitempty" bson:"label,omitempty"`
+	Type      FieldType `json:"type" bson:"type"`
+	Required  bool      `json:"required" bson:"required"`
+	Enum      []string  `json:"enum,omitempty" bson:"enum,omitempty"`
+	MaxLength int       `json:"max_length,omitempty" bson:"max_length,omitempty"`
+}
+
+// FieldType enum type
+type FieldType string
+
+const (
+	TEXT_FIELD_TYPE    FieldType = "text"
+	NUMBER_FIELD_TYPE  FieldType = "number"
+	BOOLEAN_FIELD_TYPE FieldType = "boolean"
+)
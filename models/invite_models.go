@@ -0,0 +1,41 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Invite is a single-use code admitting one entry off the waitlist. Only the
+// hash of the code is stored, the code itself is only ever sent to the entry.
+type Invite struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TenantID   string             `json:"-" bson:"tenant_id,omitempty"`
+	CodeHash   string             `json:"-" bson:"code_hash"`
+	EntryID    primitive.ObjectID `json:"entry_id" bson:"entry_id"`
+	Waitlist   string             `json:"waitlist" bson:"waitlist"`
+	Email      string             `json:"email" bson:"email"`
+	WaveID     primitive.ObjectID `json:"wave_id" bson:"wave_id"`
+	Status     InviteStatus       `json:"status" bson:"status"`
+	ExpiresAt  int64              `json:"expires_at" bson:"expires_at"`
+	CreatedAt  int64              `json:"created_at" bson:"created_at"`
+	AcceptedAt int64              `json:"accepted_at,omitempty" bson:"accepted_at,omitempty"`
+}
+
+// InviteStatus enum type
+type InviteStatus string
+
+const (
+	SENT_INVITE_STATUS     InviteStatus = "sent"
+	ACCEPTED_INVITE_STATUS InviteStatus = "accepted"
+	EXPIRED_INVITE_STATUS  InviteStatus = "expired"
+	REVOKED_INVITE_STATUS  InviteStatus = "revoked"
+)
+
+// InviteWave is one release of entries off the waitlist
+type InviteWave struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Requested   int                `json:"requested" bson:"requested"`
+	Released    int                `json:"released" bson:"released"`
+	Audience    AudienceFilter     `json:"audience" bson:"audience"`
+	ExpiresIn   int64              `json:"expires_in_hours" bson:"expires_in_hours"`
+	Source      string             `json:"source" bson:"source"`
+	CreatedAt   int64              `json:"created_at" bson:"created_at"`
+	CompletedAt int64              `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+}
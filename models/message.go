@@ -4,15 +4,27 @@ package models
 type Message struct {
 	ID          string            `json:"id" bson:"id"`
 	CustomerID  string            `json:"customer_id" bson:"customer_id"`
-	AccountID   string            `json:"account_id" bson:"account_id"`
+	AccountID   string            `json:"account_id" bson:"account_id"` // the tenant sending the message
+	Waitlist    string            `json:"waitlist,omitempty" bson:"waitlist,omitempty"`
+	Sender      string            `json:"sender,omitempty" bson:"sender,omitempty"`
 	Target      string            `json:"target" bson:"target"`
 	Type        MessageType       `json:"type" bson:"type"`
 	Title       string            `json:"title" bson:"title"`
 	Body        string            `json:"body" bson:"body"`
 	TemplateID  string            `json:"template_id" bson:"template_id"`
 	DataMap     map[string]string `json:"data_map" bson:"data_map"`
+	Headers     map[string]string `json:"headers,omitempty" bson:"headers,omitempty"`
 	Attachments []Attachment      `json:"attachments" bson:"attachments"`
 	Ts          int64             `json:"ts" bson:"ts"`
+	// Secrets are values the message log must not store, such as invite codes
+	Secrets []string `json:"-" bson:"-"`
+
+	// delivery details, filled in by the clients and the message log
+	Provider          string        `json:"provider,omitempty" bson:"provider,omitempty"`
+	ProviderMessageID string        `json:"provider_message_id,omitempty" bson:"provider_message_id,omitempty"`
+	Status            MessageStatus `json:"status,omitempty" bson:"status,omitempty"`
+	Error             string        `json:"error,omitempty" bson:"error,omitempty"`
+	SentAt            int64         `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
 }
 
 type Attachment struct {
@@ -29,3 +41,12 @@ const (
 	EMAIL_MESSAGE_TYPE MessageType = "EMAIL"
 	SMS_MESSAGE_TYPE   MessageType = "SMS"
 )
+
+// MessageStatus enum type
+type MessageStatus string
+
+const (
+	SENT_MESSAGE_STATUS    MessageStatus = "sent"
+	FAILED_MESSAGE_STATUS  MessageStatus = "failed"
+	SKIPPED_MESSAGE_STATUS MessageStatus = "skipped"
+)
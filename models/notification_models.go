@@ -0,0 +1,28 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// NotificationSettings configures the team notifications of one waitlist,
+// posted to a Slack compatible incoming webhook
+type NotificationSettings struct {
+	Waitlist   string  `json:"waitlist" bson:"_id"`
+	TenantID   string  `json:"-" bson:"tenant_id,omitempty"`
+	WebhookURL string  `json:"webhook_url" bson:"webhook_url"`
+	Milestones []int64 `json:"milestones" bson:"milestones"`
+	Digest     bool    `json:"digest" bson:"digest"`
+	// DigestHour is the hour of the day the digest is posted, in Timezone
+	DigestHour   int    `json:"digest_hour" bson:"digest_hour"`
+	Timezone     string `json:"timezone" bson:"timezone"`
+	NextDigestAt int64  `json:"next_digest_at,omitempty" bson:"next_digest_at,omitempty"`
+	UpdatedAt    int64  `json:"updated_at" bson:"updated_at"`
+}
+
+// Milestone records a signup threshold a waitlist has crossed, so it is announced once
+type Milestone struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TenantID  string             `json:"-" bson:"tenant_id,omitempty"`
+	Waitlist  string             `json:"waitlist" bson:"waitlist"`
+	Threshold int64              `json:"threshold" bson:"threshold"`
+	Count     int64              `json:"count" bson:"count"`
+	ReachedAt int64              `json:"reached_at" bson:"reached_at"`
+}
@@ -0,0 +1,11 @@
+package models
+
+// Organization is a tenant, such as an agency client. Admins belong to one or
+// more organizations and every document carries the id of the one owning it.
+type Organization struct {
+	ID            string `json:"id" bson:"_id"`
+	Name          string `json:"name" bson:"name"`
+	SenderEmail   string `json:"sender_email,omitempty" bson:"sender_email,omitempty"`
+	PostmarkToken string `json:"-" bson:"postmark_token,omitempty"`
+	CreatedAt     int64  `json:"created_at" bson:"created_at"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// PrivacyRequest is a data subject request made by the owner of an email
+// address. Only the hash of the link token is stored. Once the data is erased
+// the address itself is cleared and only its hash remains with the trail.
+type PrivacyRequest struct {
+	ID          primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	TenantID    string               `json:"-" bson:"tenant_id,omitempty"`
+	Waitlist    string               `json:"waitlist" bson:"waitlist"`
+	Email       string               `json:"email,omitempty" bson:"email,omitempty"`
+	EmailHash   string               `json:"email_hash" bson:"email_hash"`
+	Type        PrivacyRequestType   `json:"type" bson:"type"`
+	Status      PrivacyRequestStatus `json:"status" bson:"status"`
+	TokenHash   string               `json:"-" bson:"token_hash"`
+	ExpiresAt   int64                `json:"expires_at" bson:"expires_at"`
+	CreatedAt   int64                `json:"created_at" bson:"created_at"`
+	VerifiedAt  int64                `json:"verified_at,omitempty" bson:"verified_at,omitempty"`
+	CompletedAt int64                `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+	// Erased counts the documents removed from each collection
+	Erased map[string]int64 `json:"erased,omitempty" bson:"erased,omitempty"`
+	Trail  []PrivacyEvent   `json:"trail" bson:"trail"`
+}
+
+// PrivacyEvent is one step of the audit trail of a privacy request
+type PrivacyEvent struct {
+	Action    string `json:"action" bson:"action"`
+	IP        string `json:"ip,omitempty" bson:"ip,omitempty"`
+	Detail    string `json:"detail,omitempty" bson:"detail,omitempty"`
+	Timestamp int64  `json:"timestamp" bson:"timestamp"`
+}
+
+// PrivacyRequestType enum type
+type PrivacyRequestType string
+
+const (
+	ACCESS_PRIVACY_REQUEST_TYPE  PrivacyRequestType = "access"
+	ERASURE_PRIVACY_REQUEST_TYPE PrivacyRequestType = "erasure"
+)
+
+// PrivacyRequestStatus enum type
+type PrivacyRequestStatus string
+
+const (
+	PENDING_PRIVACY_REQUEST_STATUS   PrivacyRequestStatus = "pending"
+	COMPLETED_PRIVACY_REQUEST_STATUS PrivacyRequestStatus = "completed"
+)
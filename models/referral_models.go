@@ -0,0 +1,65 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// referralEncoding spells referral codes, lowercase so they survive being typed in
+var referralEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// NewReferralCode returns a random code for an entry to share
+func NewReferralCode() (string, error) {
+	buf := make([]byte, 5)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return referralEncoding.EncodeToString(buf), nil
+}
+
+// ReferralSettings configures the rewards of one waitlist's referral program
+type ReferralSettings struct {
+	Waitlist  string       `json:"waitlist" bson:"_id"`
+	TenantID  string       `json:"-" bson:"tenant_id,omitempty"`
+	Tiers     []RewardTier `json:"tiers" bson:"tiers"`
+	UpdatedAt int64        `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+}
+
+// RewardTier is earned by an entry once it referred Referrals signups
+type RewardTier struct {
+	Name      string `json:"name" bson:"name"`
+	Referrals int    `json:"referrals" bson:"referrals"`
+	// Template is the email template alias announcing the reward
+	Template string `json:"template,omitempty" bson:"template,omitempty"`
+}
+
+// ReferralReward records a tier earned by an entry and the email announcing it.
+// Rewards are unique per entry and tier, so each one is only sent once.
+type ReferralReward struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TenantID  string             `json:"-" bson:"tenant_id,omitempty"`
+	Waitlist  string             `json:"waitlist" bson:"waitlist"`
+	EntryID   primitive.ObjectID `json:"entry_id" bson:"entry_id"`
+	Email     string             `json:"email" bson:"email"`
+	Tier      string             `json:"tier" bson:"tier"`
+	Referrals int                `json:"referrals" bson:"referrals"`
+	Template  string             `json:"template" bson:"template"`
+	Status    RewardStatus       `json:"status" bson:"status"`
+	Error     string             `json:"error,omitempty" bson:"error,omitempty"`
+	EarnedAt  int64              `json:"earned_at" bson:"earned_at"`
+	UpdatedAt int64              `json:"updated_at" bson:"updated_at"`
+	SentAt    int64              `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
+}
+
+// RewardStatus enum type, where the email of a reward is
+type RewardStatus string
+
+const (
+	PENDING_REWARD_STATUS RewardStatus = "pending"
+	SENDING_REWARD_STATUS RewardStatus = "sending"
+	SENT_REWARD_STATUS    RewardStatus = "sent"
+	SKIPPED_REWARD_STATUS RewardStatus = "skipped"
+	FAILED_REWARD_STATUS  RewardStatus = "failed"
+)
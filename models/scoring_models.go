@@ -0,0 +1,87 @@
+package models
+
+import "fmt"
+
+// ScoringSettings configures how the entries of one waitlist are ranked. While
+// enabled, the queue is ordered by score, then by signup time.
+type ScoringSettings struct {
+	Waitlist string `json:"waitlist" bson:"_id"`
+	TenantID string `json:"-" bson:"tenant_id,omitempty"`
+	Enabled  bool   `json:"enabled" bson:"enabled"`
+	// SignupDay is taken off for every day an entry signed up after the waitlist opened
+	SignupDay float64      `json:"signup_day" bson:"signup_day"`
+	Referral  float64      `json:"referral" bson:"referral"`
+	Confirmed float64      `json:"confirmed" bson:"confirmed"`
+	Tags      []TagScore   `json:"tags,omitempty" bson:"tags,omitempty"`
+	Fields    []FieldScore `json:"fields,omitempty" bson:"fields,omitempty"`
+	// Version changes with every update. Entries are rescored in the background
+	// until ScoredVersion catches up.
+	Version       int64 `json:"version" bson:"version"`
+	ScoredVersion int64 `json:"scored_version" bson:"scored_version"`
+	// ScoringStartedAt is set while a replica rescores the entries
+	ScoringStartedAt int64 `json:"scoring_started_at,omitempty" bson:"scoring_started_at,omitempty"`
+	UpdatedAt        int64 `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+}
+
+// TagScore awards Weight to entries carrying Tag
+type TagScore struct {
+	Tag    string  `json:"tag" bson:"tag"`
+	Weight float64 `json:"weight" bson:"weight"`
+}
+
+// FieldScore scores a custom signup field. With a Value, entries whose field
+// equals it get Weight. Without one, number fields score their value times
+// Weight and boolean fields score Weight when true.
+type FieldScore struct {
+	Field  string  `json:"field" bson:"field"`
+	Value  string  `json:"value,omitempty" bson:"value,omitempty"`
+	Weight float64 `json:"weight" bson:"weight"`
+}
+
+// Score returns the score of entry on list, including its manual boost
+func (s *ScoringSettings) Score(list *Waitlist, entry *WaitlistEntry) float64 {
+	score := entry.Boost
+
+	if late := entry.Timestamp - list.CreatedAt; late > 0 {
+		score -= s.SignupDay * float64(late) / 86400
+	}
+	score += s.Referral * float64(entry.ReferralCount)
+	if entry.ConfirmedAt > 0 {
+		score += s.Confirmed
+	}
+
+	for _, tag := range s.Tags {
+		for _, t := range entry.Tags {
+			if t == tag.Tag {
+				score += tag.Weight
+				break
+			}
+		}
+	}
+
+	for _, field := range s.Fields {
+		value, ok := entry.Metadata[field.Field]
+		if !ok {
+			continue
+		}
+		if field.Value != "" {
+			if fmt.Sprint(value) == field.Value {
+				score += field.Weight
+			}
+			continue
+		}
+		switch v := value.(type) {
+		case float64:
+			score += v * field.Weight
+		case int32:
+			score += float64(v) * field.Weight
+		case int64:
+			score += float64(v) * field.Weight
+		case bool:
+			if v {
+				score += field.Weight
+			}
+		}
+	}
+	return score
+}
@@ -0,0 +1,40 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Suppression blocks every outgoing message to an address or a whole domain
+type Suppression struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TenantID  string             `json:"-" bson:"tenant_id"`
+	Value     string             `json:"value" bson:"value"`
+	Kind      SuppressionKind    `json:"kind" bson:"kind"`
+	Reason    SuppressionReason  `json:"reason" bson:"reason"`
+	Timestamp int64              `json:"timestamp" bson:"timestamp"`
+}
+
+// SuppressionKind enum type
+type SuppressionKind string
+
+const (
+	EMAIL_SUPPRESSION_KIND  SuppressionKind = "email"
+	DOMAIN_SUPPRESSION_KIND SuppressionKind = "domain"
+)
+
+// SuppressionReason enum type
+type SuppressionReason string
+
+const (
+	BOUNCE_SUPPRESSION_REASON      SuppressionReason = "bounce"
+	COMPLAINT_SUPPRESSION_REASON   SuppressionReason = "complaint"
+	UNSUBSCRIBE_SUPPRESSION_REASON SuppressionReason = "unsubscribe"
+	MANUAL_SUPPRESSION_REASON      SuppressionReason = "manual"
+)
+
+// Valid reports whether r is one of the known suppression reasons
+func (r SuppressionReason) Valid() bool {
+	switch r {
+	case BOUNCE_SUPPRESSION_REASON, COMPLAINT_SUPPRESSION_REASON, UNSUBSCRIBE_SUPPRESSION_REASON, MANUAL_SUPPRESSION_REASON:
+		return true
+	}
+	return false
+}
@@ -1,14 +1,154 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultWaitlist is the slug of the waitlist served by the original unscoped routes
+const DefaultWaitlist = "default"
+
+// Waitlist is a named list, one per product launch. Entries reference it by slug.
+type Waitlist struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TenantID    string             `json:"-" bson:"tenant_id,omitempty"`
+	Slug        string             `json:"slug" bson:"slug"`
+	Name        string             `json:"name" bson:"name"`
+	SenderEmail string             `json:"sender_email,omitempty" bson:"sender_email,omitempty"`
+	Templates   WaitlistTemplates  `json:"templates" bson:"templates"`
+	Settings    WaitlistSettings   `json:"settings" bson:"settings"`
+	Fields      []SignupField      `json:"fields,omitempty" bson:"fields,omitempty"`
+	CreatedAt   int64              `json:"created_at" bson:"created_at"`
+}
+
+// WaitlistTemplates overrides the default email template aliases of a waitlist
+type WaitlistTemplates struct {
+	Signup string `json:"signup,omitempty" bson:"signup,omitempty"`
+	Invite string `json:"invite,omitempty" bson:"invite,omitempty"`
+}
+
+// WaitlistSettings holds the behaviour switches of a waitlist
+type WaitlistSettings struct {
+	Closed            bool  `json:"closed" bson:"closed"`
+	InviteExpiryHours int64 `json:"invite_expiry_hours,omitempty" bson:"invite_expiry_hours,omitempty"`
+	// RetentionDays is how long deleted entries can be restored before they are purged
+	RetentionDays int `json:"retention_days,omitempty" bson:"retention_days,omitempty"`
+	// PriorityTags move the entries carrying any of them to the front of the queue
+	PriorityTags []string `json:"priority_tags,omitempty" bson:"priority_tags,omitempty"`
+}
 
 type WaitlistEntry struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty"`
-	Email     string             `bson:"email"`
-	Timestamp int64              `json:"timestamp" bson:"timestamp"`
+	ID            primitive.ObjectID `bson:"_id,omitempty"`
+	TenantID      string             `json:"-" bson:"tenant_id,omitempty"`
+	Waitlist      string             `json:"waitlist" bson:"waitlist"`
+	Email         string             `bson:"email"`
+	Phone         string             `json:"phone,omitempty" bson:"phone,omitempty"`
+	Timestamp     int64              `json:"timestamp" bson:"timestamp"`
+	Status        EntryStatus        `json:"status,omitempty" bson:"status,omitempty"`
+	ConfirmedAt   int64              `json:"confirmed_at,omitempty" bson:"confirmed_at,omitempty"`
+	ReferralCount int                `json:"referral_count" bson:"referral_count"`
+	OptOut        OptOutScope        `json:"opt_out,omitempty" bson:"opt_out,omitempty"`
+	OptOutAt      int64              `json:"opt_out_at,omitempty" bson:"opt_out_at,omitempty"`
+	InvitedAt     int64              `json:"invited_at,omitempty" bson:"invited_at,omitempty"`
+	AcceptedAt    int64              `json:"accepted_at,omitempty" bson:"accepted_at,omitempty"`
+	Tags          []string           `json:"tags,omitempty" bson:"tags,omitempty"`
+	Notes         []EntryNote        `json:"notes,omitempty" bson:"notes,omitempty"`
+	// Priority is set while the entry carries a priority tag of its waitlist
+	Priority bool `json:"priority,omitempty" bson:"priority,omitempty"`
+	// Score ranks the entry when its waitlist has scoring enabled. Boost is
+	// added to it by hand.
+	Score float64 `json:"score,omitempty" bson:"score"`
+	Boost float64 `json:"boost,omitempty" bson:"boost,omitempty"`
+	// ReferralCode is shared by the entry to refer others, ReferredBy is the entry that referred it
+	ReferralCode string             `json:"referral_code,omitempty" bson:"referral_code,omitempty"`
+	ReferredBy   primitive.ObjectID `json:"referred_by,omitempty" bson:"referred_by,omitempty"`
+	// soft deleted entries are hidden until they are restored or purged
+	DeletedAt int64 `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	PurgeAt   int64 `json:"purge_at,omitempty" bson:"purge_at,omitempty"`
+	// Metadata holds the custom signup fields of the waitlist, keyed by field key
+	Metadata    map[string]interface{} `json:"metadata,omitempty" bson:"metadata,omitempty"`
+	Acquisition *Acquisition           `json:"acquisition,omitempty" bson:"acquisition,omitempty"`
+}
+
+// Published returns a copy of the entry for event payloads, which leave the
+// admin tools, without its internal notes and ranking
+func (e *WaitlistEntry) Published() *WaitlistEntry {
+	if e == nil {
+		return nil
+	}
+	published := *e
+	published.Notes = nil
+	published.Score, published.Boost = 0, 0
+	return &published
+}
+
+// EntryNote is an internal admin note on an entry, never shown to the subscriber
+type EntryNote struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Text      string             `json:"text" bson:"text"`
+	Author    string             `json:"author,omitempty" bson:"author,omitempty"`
+	CreatedAt int64              `json:"created_at" bson:"created_at"`
+}
+
+// NormalizeTags returns tags trimmed and lowercased, without blanks and duplicates
+func NormalizeTags(tags []string) []string {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
 }
 
+// Acquisition records where a signup came from
+type Acquisition struct {
+	Source      string `json:"source,omitempty" bson:"source,omitempty"`
+	Medium      string `json:"medium,omitempty" bson:"medium,omitempty"`
+	Campaign    string `json:"campaign,omitempty" bson:"campaign,omitempty"`
+	Term        string `json:"term,omitempty" bson:"term,omitempty"`
+	Content     string `json:"content,omitempty" bson:"content,omitempty"`
+	Referer     string `json:"referer,omitempty" bson:"referer,omitempty"`
+	LandingPage string `json:"landing_page,omitempty" bson:"landing_page,omitempty"`
+	UserAgent   string `json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+}
+
+// EntryStatus enum type, where an entry is in the admission lifecycle
+type EntryStatus string
+
+const (
+	WAITING_ENTRY_STATUS  EntryStatus = "waiting"
+	INVITED_ENTRY_STATUS  EntryStatus = "invited"
+	ACCEPTED_ENTRY_STATUS EntryStatus = "accepted"
+	EXPIRED_ENTRY_STATUS  EntryStatus = "expired"
+)
+
+// Valid reports whether s is a known entry status
+func (s EntryStatus) Valid() bool {
+	switch s {
+	case WAITING_ENTRY_STATUS, INVITED_ENTRY_STATUS, ACCEPTED_ENTRY_STATUS, EXPIRED_ENTRY_STATUS:
+		return true
+	}
+	return false
+}
+
+// OptOutScope enum type, records what a recipient unsubscribed from
+type OptOutScope string
+
+const (
+	EMAILS_OPT_OUT_SCOPE   OptOutScope = "emails"
+	WAITLIST_OPT_OUT_SCOPE OptOutScope = "waitlist"
+)
+
 type SiginDetails struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email         string   `json:"email"`
+	Password      string   `json:"password"`
+	Organizations []string `json:"organizations,omitempty" bson:"organizations"`
+	// Organization picks the active organization at signin, it isn't stored
+	Organization string `json:"organization,omitempty" bson:"-"`
 }
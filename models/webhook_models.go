@@ -0,0 +1,45 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Webhook is an admin registered URL receiving signed event payloads
+type Webhook struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TenantID  string             `json:"-" bson:"tenant_id,omitempty"`
+	URL       string             `json:"url" bson:"url"`
+	Events    []EventType        `json:"events" bson:"events"`
+	Secret    string             `json:"-" bson:"secret"`
+	Active    bool               `json:"active" bson:"active"`
+	CreatedAt int64              `json:"created_at" bson:"created_at"`
+}
+
+// WebhookDelivery is one attempt queue entry and log record of an event sent to a webhook
+type WebhookDelivery struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TenantID  string             `json:"-" bson:"tenant_id,omitempty"`
+	WebhookID primitive.ObjectID `json:"webhook_id" bson:"webhook_id"`
+	EventID   string             `json:"event_id" bson:"event_id"`
+	Event     EventType          `json:"event" bson:"event"`
+	// Email is the address the event is about, kept so deliveries can be erased with it
+	Email          string             `json:"email,omitempty" bson:"email,omitempty"`
+	Payload        string             `json:"payload" bson:"payload"`
+	Status         DeliveryStatus     `json:"status" bson:"status"`
+	Attempts       int                `json:"attempts" bson:"attempts"`
+	NextAttemptAt  int64              `json:"next_attempt_at,omitempty" bson:"next_attempt_at,omitempty"`
+	ResponseStatus int                `json:"response_status,omitempty" bson:"response_status,omitempty"`
+	Error          string             `json:"error,omitempty" bson:"error,omitempty"`
+	RedeliveryOf   primitive.ObjectID `json:"redelivery_of,omitempty" bson:"redelivery_of,omitempty"`
+	CreatedAt      int64              `json:"created_at" bson:"created_at"`
+	UpdatedAt      int64              `json:"updated_at" bson:"updated_at"`
+	DeliveredAt    int64              `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+}
+
+// DeliveryStatus enum type
+type DeliveryStatus string
+
+const (
+	PENDING_DELIVERY_STATUS    DeliveryStatus = "pending"
+	DELIVERING_DELIVERY_STATUS DeliveryStatus = "delivering"
+	DELIVERED_DELIVERY_STATUS  DeliveryStatus = "delivered"
+	FAILED_DELIVERY_STATUS     DeliveryStatus = "failed"
+)
@@ -1,26 +1,200 @@
 package routes
 
 import (
+	"context"
+	"log"
+	"os"
+	"strings"
 	"waitlist/controllers"
 	"waitlist/db"
+	"waitlist/lib/audit"
+	"waitlist/lib/emailclient"
 	"waitlist/lib/emailclient/postmark"
+	"waitlist/lib/linksigner"
+	"waitlist/lib/smsclient"
+	"waitlist/lib/smsclient/httpsms"
+	"waitlist/lib/suppression"
+	"waitlist/lib/tenant"
 	"waitlist/middleware"
 
 	"github.com/gin-gonic/gin"
 )
 
 func SetupRoutes(router *gin.Engine, authConn *middleware.AuthConn) {
-	email := postmark.New()
-	wt := controllers.NewWaitlist(db.ConnectDatabase(), email, authConn)
+	database := db.ConnectDatabase()
+	suppressions := suppression.New(database)
 
-	// Group routes that require authentication
-	authGroup := router.Group("/api", middleware.AuthMiddleware(authConn))
+	linkSecret := os.Getenv("LINK_SECRET")
+	if linkSecret == "" {
+		log.Fatal("environment variable not set")
+	}
+	signer := linksigner.New(linkSecret, os.Getenv("PUBLIC_URL"))
+
+	// every organization sends through its own Postmark server and sender identity
+	organizations := tenant.NewDirectory(database)
+	postmarkAccount := func(account string) (string, string, error) {
+		organization, err := organizations.Get(context.Background(), account)
+		if err != nil {
+			return "", "", err
+		}
+		return organization.PostmarkToken, organization.SenderEmail, nil
+	}
+	postmarkURL := os.Getenv("POSTMARK_API_URL")
+	if postmarkURL == "" {
+		postmarkURL = postmark.APIURL
+	}
+	email := emailclient.WithSuppression(emailclient.WithUnsubscribe(postmark.NewPerAccount(postmarkURL, postmarkAccount), signer), suppressions)
+
+	// SMS is optional, without a provider signups only get an email
+	var sms smsclient.SMSClient
+	if os.Getenv("SMS_API_URL") != "" {
+		sms = httpsms.New()
+	}
+
+	// every change made by an admin goes to the hash chained audit log
+	trail := audit.New(database)
+
+	wt := controllers.NewWaitlist(database, email, sms, suppressions, signer, organizations, authConn, trail)
+
+	// Background jobs
+	go wt.RunCampaigns(context.Background())
+	go wt.RunDrips(context.Background())
+	go wt.RunInviteExpiry(context.Background())
+	go wt.RunAdmission(context.Background())
+	go wt.RunFeed(context.Background())
+	go wt.RunWebhooks(context.Background())
+	go wt.RunNotifications(context.Background())
+	go wt.RunPurge(context.Background())
+	go wt.RunBulkJobs(context.Background())
+	go wt.RunScoring(context.Background())
+	go wt.RunRewards(context.Background())
+
+	// Organizations of the signed in admin, available before one is active
+	orgGroup := router.Group("/api/organizations", middleware.AuthMiddleware(authConn), middleware.AuditMiddleware(trail))
+	{
+		orgGroup.GET("", wt.GetOrganizations())
+		orgGroup.POST("", wt.CreateOrganization())
+		orgGroup.PUT("/:id", wt.UpdateOrganization())
+		orgGroup.POST("/:id/members", wt.AddOrganizationMember())
+		orgGroup.POST("/:id/switch", wt.SwitchOrganization())
+	}
+
+	// Group routes that require authentication, scoped to the active organization
+	authGroup := router.Group("/api", middleware.AuthMiddleware(authConn), middleware.TenantMiddleware(), middleware.AuditMiddleware(trail))
 	{
 		authGroup.GET("/getWaitlist", wt.GetWaitList())
 		authGroup.DELETE("/deleteWaitlist/:email", wt.DeleteFromWaitlist())
+		authGroup.GET("/export", wt.ExportWaitlist())
+		authGroup.GET("/deleted", wt.GetDeletedEntries())
+		authGroup.POST("/deleted/:email/restore", wt.RestoreEntry())
+		authGroup.POST("/entries/:email/tags", wt.AddEntryTags())
+		authGroup.DELETE("/entries/:email/tags/:tag", wt.RemoveEntryTag())
+		authGroup.POST("/entries/:email/notes", wt.AddEntryNote())
+		authGroup.DELETE("/entries/:email/notes/:id", wt.DeleteEntryNote())
+		authGroup.PUT("/entries/:email/boost", wt.SetEntryBoost())
+		authGroup.GET("/entries/:email/position", wt.GetEntryPosition())
+
+		authGroup.GET("/waitlists", wt.GetWaitlists())
+		authGroup.POST("/waitlists", wt.CreateWaitlist())
+		authGroup.GET("/waitlists/:slug", wt.GetWaitlistConfig())
+		authGroup.PUT("/waitlists/:slug", wt.UpdateWaitlist())
+		authGroup.GET("/waitlists/:slug/entries", wt.GetWaitList())
+		authGroup.DELETE("/waitlists/:slug/entries/:email", wt.DeleteFromWaitlist())
+		authGroup.POST("/waitlists/:slug/entries/:email/tags", wt.AddEntryTags())
+		authGroup.DELETE("/waitlists/:slug/entries/:email/tags/:tag", wt.RemoveEntryTag())
+		authGroup.POST("/waitlists/:slug/entries/:email/notes", wt.AddEntryNote())
+		authGroup.DELETE("/waitlists/:slug/entries/:email/notes/:id", wt.DeleteEntryNote())
+		authGroup.PUT("/waitlists/:slug/entries/:email/boost", wt.SetEntryBoost())
+		authGroup.GET("/waitlists/:slug/entries/:email/position", wt.GetEntryPosition())
+		authGroup.GET("/waitlists/:slug/export", wt.ExportWaitlist())
+		authGroup.GET("/waitlists/:slug/deleted", wt.GetDeletedEntries())
+		authGroup.POST("/waitlists/:slug/deleted/:email/restore", wt.RestoreEntry())
+		authGroup.POST("/waitlists/:slug/bulk", wt.BulkUpdateEntries())
+		authGroup.POST("/bulk", wt.BulkUpdateEntries())
+		authGroup.GET("/bulk/jobs/:id", wt.GetBulkJob())
+		authGroup.GET("/bulk/jobs/:id/items", wt.GetBulkJobItems())
+		authGroup.GET("/waitlists/:slug/analytics/acquisition", wt.GetAcquisitionAnalytics())
+		authGroup.GET("/analytics/acquisition", wt.GetAcquisitionAnalytics())
+		authGroup.GET("/waitlists/:slug/stats", wt.GetStats())
+		authGroup.GET("/stats", wt.GetStats())
+		authGroup.GET("/feed", wt.GetFeed())
+
+		authGroup.GET("/suppressions", wt.GetSuppressions())
+		authGroup.POST("/suppressions", wt.AddSuppression())
+		authGroup.DELETE("/suppressions/:value", wt.DeleteSuppression())
+
+		authGroup.POST("/campaigns", wt.CreateCampaign())
+		authGroup.GET("/campaigns", wt.GetCampaigns())
+		authGroup.GET("/campaigns/:id", wt.GetCampaign())
+		authGroup.GET("/campaigns/:id/recipients", wt.GetCampaignRecipients())
+		authGroup.POST("/campaigns/:id/pause", wt.PauseCampaign())
+		authGroup.POST("/campaigns/:id/resume", wt.ResumeCampaign())
+		authGroup.POST("/campaigns/:id/cancel", wt.CancelCampaign())
+
+		authGroup.POST("/drips", wt.CreateDripSequence())
+		authGroup.GET("/drips", wt.GetDripSequences())
+		authGroup.PUT("/drips/:id", wt.UpdateDripSequence())
+		authGroup.DELETE("/drips/:id", wt.DeleteDripSequence())
+		authGroup.GET("/drips/:id/messages", wt.GetDripMessages())
+
+		authGroup.GET("/messages", wt.GetMessages())
+		authGroup.GET("/messages/:id", wt.GetMessage())
+
+		authGroup.POST("/invites/waves", wt.ReleaseInviteWave())
+		authGroup.GET("/invites/waves", wt.GetInviteWaves())
+		authGroup.GET("/invites", wt.GetInvites())
+		authGroup.POST("/invites/resend/:email", wt.ResendInvite())
+
+		authGroup.GET("/admission", wt.GetAdmissionSettings())
+		authGroup.PUT("/admission", wt.UpdateAdmissionSettings())
+		authGroup.POST("/admission/pause", wt.PauseAdmission())
+		authGroup.POST("/admission/resume", wt.ResumeAdmission())
+		authGroup.GET("/admission/runs", wt.GetAdmissionRuns())
+
+		authGroup.GET("/notifications", wt.GetNotificationSettings())
+		authGroup.PUT("/notifications", wt.UpdateNotificationSettings())
+		authGroup.POST("/notifications/test", wt.TestNotification())
+		authGroup.GET("/notifications/milestones", wt.GetMilestones())
+
+		authGroup.GET("/scoring", wt.GetScoringSettings())
+		authGroup.PUT("/scoring", wt.UpdateScoringSettings())
+		authGroup.POST("/scoring/recalculate", wt.RecalculateScores())
+
+		authGroup.GET("/referrals", wt.GetReferralSettings())
+		authGroup.PUT("/referrals", wt.UpdateReferralSettings())
+		authGroup.GET("/referrals/rewards", wt.GetReferralRewards())
+
+		authGroup.GET("/privacy/requests", wt.GetPrivacyRequests())
+
+		authGroup.GET("/audit", wt.GetAuditLog())
+		authGroup.GET("/audit/verify", wt.VerifyAuditLog())
+
+		authGroup.POST("/webhooks", wt.CreateWebhook())
+		authGroup.GET("/webhooks", wt.GetWebhooks())
+		authGroup.PUT("/webhooks/:id", wt.UpdateWebhook())
+		authGroup.DELETE("/webhooks/:id", wt.DeleteWebhook())
+		authGroup.GET("/webhooks/:id/deliveries", wt.GetWebhookDeliveries())
+		authGroup.POST("/webhooks/:id/deliveries/:delivery/redeliver", wt.RedeliverWebhook())
+	}
+
+	// Service to service routes, authenticated with an API key or a client certificate
+	serviceGroup := router.Group("/api/service", middleware.ServiceAuthMiddleware(strings.Split(os.Getenv("SERVICE_API_KEYS"), ",")))
+	{
+		serviceGroup.POST("/invites/redeem", wt.RedeemInvite())
 	}
 
 	router.POST("/api/addWaitlist", wt.AddToWaitlist())
+	router.POST("/api/waitlists/:slug/signup", wt.AddToWaitlist())
+	router.GET("/api/leaderboard", wt.GetLeaderboard())
+	router.GET("/api/waitlists/:slug/leaderboard", wt.GetLeaderboard())
+	router.POST("/api/privacy/requests", wt.RequestPrivacy())
+	router.GET("/api/privacy", wt.GetPrivacyRequest())
+	router.GET("/api/privacy/export", wt.ExportPrivacyData())
+	router.POST("/api/privacy/erase", wt.ErasePrivacyData())
 	router.POST("/api/signin", wt.Signin())
-	router.POST("/api/create", wt.CreateAdmin())
+	router.POST("/api/create", middleware.AuditMiddleware(trail), wt.CreateAdmin())
+
+	router.GET("/api/confirm", wt.ConfirmEmail())
+	router.GET("/api/unsubscribe", wt.GetUnsubscribe())
+	router.POST("/api/unsubscribe", wt.Unsubscribe())
 }